@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/jenningsloy318/vsphere_exporter/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// AggregateGatherer scrapes several targets concurrently and labels their series with "vcenter".
+// It implements prometheus.Gatherer.
+type AggregateGatherer struct {
+	ctx     context.Context
+	targets map[string]*config.ClusterConfig
+	workers int
+}
+
+// NewAggregateGatherer returns a gatherer scraping the targets with at most workers scrapes in flight
+func NewAggregateGatherer(ctx context.Context, targets map[string]*config.ClusterConfig, workers int) *AggregateGatherer {
+	if workers < 1 {
+		workers = 1
+	}
+	return &AggregateGatherer{
+		ctx:     ctx,
+		targets: targets,
+		workers: workers,
+	}
+}
+
+// Gather implements prometheus.Gatherer. A failing target is reported by its vsphere_up series and doesn't fail the others.
+func (a *AggregateGatherer) Gather() ([]*dto.MetricFamily, error) {
+	targets := make([]string, 0, len(a.targets))
+	for target := range a.targets {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	gatherers := make(prometheus.Gatherers, len(targets))
+	targetCh := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < a.workers && i < len(targets); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range targetCh {
+				gatherers[index] = a.gatherTarget(targets[index])
+			}
+		}()
+	}
+	for index := range targets {
+		targetCh <- index
+	}
+	close(targetCh)
+	wg.Wait()
+
+	return gatherers.Gather()
+}
+
+// gatherTarget scrapes a single target and returns its result as a gatherer
+func (a *AggregateGatherer) gatherTarget(target string) prometheus.Gatherer {
+	registry := prometheus.NewRegistry()
+	logger := logging.FromContext(a.ctx).With(logging.ModuleKey, "clusters["+target+"]")
+	collector := NewVshpereCollector(logging.NewContext(a.ctx, logger), target, a.targets[target])
+	// a custom metric clashing with a built-in metric fails the registration
+	if err := prometheus.WrapRegistererWith(prometheus.Labels{"vcenter": target}, registry).Register(collector); err != nil {
+		collector.logger.Error("Errors occour when registering the collector", "err", err)
+	}
+	metricFamilies, err := registry.Gather()
+	if err != nil {
+		collector.logger.Error("Errors occour when scraping target", "err", err)
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return metricFamilies, nil
+	})
+}
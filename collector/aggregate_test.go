@@ -0,0 +1,58 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jenningsloy318/vsphere_exporter/config"
+)
+
+func TestAggregateGatherer(t *testing.T) {
+	clusterConfigs := map[string]*config.ClusterConfig{
+		"unreachable": {Username: "user", Password: "pass", URL: "https://127.0.0.1:1/sdk", ConnectTimeout: time.Second},
+	}
+	hosts := map[string]int{}
+	for i := 1; i <= 3; i++ {
+		target := newTestVCenter(t, i)
+		clusterConfigs[target] = &config.ClusterConfig{Username: "user", Password: "pass"}
+		hosts[target] = i
+	}
+
+	metricFamilies, err := NewAggregateGatherer(context.Background(), clusterConfigs, 2).Gather()
+	if err != nil {
+		t.Fatalf("Error when gathering all targets, %v", err)
+	}
+
+	up := findMetricFamily(metricFamilies, "vsphere_up")
+	if up == nil || len(up.Metric) != len(clusterConfigs) {
+		t.Fatalf("expected a vsphere_up series per target, got %v", up)
+	}
+	for target := range clusterConfigs {
+		metric := findMetric(up, map[string]string{"vcenter": target})
+		if metric == nil {
+			t.Fatalf("no vsphere_up series of %s", target)
+		}
+		expected := 1.0
+		if target == "unreachable" {
+			expected = 0
+		}
+		if value := metric.GetGauge().GetValue(); value != expected {
+			t.Errorf("vsphere_up of %s: expected %v, got %v", target, expected, value)
+		}
+	}
+
+	hostUptime := findMetricFamily(metricFamilies, "vsphere_host_uptime")
+	if hostUptime == nil {
+		t.Fatal("no vsphere_host_uptime metrics")
+	}
+	count := map[string]int{}
+	for _, metric := range hostUptime.Metric {
+		count[labelValue(metric, "vcenter")]++
+	}
+	for target, hosts := range hosts {
+		if count[target] != 2*hosts {
+			t.Errorf("target %s: expected %d hosts, got %d", target, 2*hosts, count[target])
+		}
+	}
+}
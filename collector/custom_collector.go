@@ -0,0 +1,256 @@
+package collector
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/jenningsloy318/vsphere_exporter/vmware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+var (
+	customMetricsMu sync.Mutex
+	customMetrics   []config.CustomMetric
+)
+
+func init() {
+	// the properties and privileges of the custom collector are given by the custom metrics of the config
+	registerCollector("custom", true, nil, nil, func(namespace string, source vmware.Source, inventory *vmware.Inventory, filters map[string]*config.FilterConfig, logger *slog.Logger) prometheus.Collector {
+		return NewCustomCollector(namespace, source, inventory, filters, logger)
+	})
+	registrations["custom"].propertiesFunc = customMetricProperties
+	registrations["custom"].privilegesFunc = customMetricPrivileges
+}
+
+// SetCustomMetrics sets the custom metrics of the config, the scrapes started afterwards export them
+func SetCustomMetrics(metrics []config.CustomMetric) {
+	customMetricsMu.Lock()
+	defer customMetricsMu.Unlock()
+	customMetrics = metrics
+}
+
+func currentCustomMetrics() []config.CustomMetric {
+	customMetricsMu.Lock()
+	defer customMetricsMu.Unlock()
+	return customMetrics
+}
+
+// customMetricProperties returns the properties retrieved for the custom metrics, by managed object type
+func customMetricProperties() map[string][]string {
+	properties := map[string][]string{}
+	for _, metric := range currentCustomMetrics() {
+		properties[metric.Object] = appendPaths(properties[metric.Object], metric)
+	}
+	return properties
+}
+
+// customMetricPrivileges gives the privileges the custom metrics need, there are none without custom metrics
+func customMetricPrivileges() []string {
+	if len(currentCustomMetrics()) == 0 {
+		return nil
+	}
+	return []string{"System.View", "System.Read"}
+}
+
+// appendPaths adds the property paths of the metric and its labels missing from paths
+func appendPaths(paths []string, metric config.CustomMetric) []string {
+	for _, path := range append([]string{metric.Property}, customLabelPaths(metric)...) {
+		if !containsString(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func customLabelPaths(metric config.CustomMetric) []string {
+	var paths []string
+	for _, label := range metric.Labels {
+		paths = append(paths, label.Property)
+	}
+	return paths
+}
+
+// A CustomCollector exports the properties of the managed objects defined by the custom metrics of the config
+type CustomCollector struct {
+	source    vmware.Source
+	inventory *vmware.Inventory
+	filters   map[string]*config.FilterConfig
+	logger    *slog.Logger
+	metrics   []customMetric
+}
+
+type customMetric struct {
+	config.CustomMetric
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+}
+
+// NewCustomCollector returns a collector exporting the custom metrics set when it is created
+func NewCustomCollector(namespace string, source vmware.Source, inventory *vmware.Inventory, filters map[string]*config.FilterConfig, logger *slog.Logger) *CustomCollector {
+	var metrics []customMetric
+	for _, metricConfig := range currentCustomMetrics() {
+		labelNames := []string{"name"}
+		for _, label := range metricConfig.Labels {
+			labelNames = append(labelNames, label.Name)
+		}
+		help := metricConfig.Help
+		if help == "" {
+			help = fmt.Sprintf("%s of %s", metricConfig.Property, metricConfig.Object)
+		}
+		valueType := prometheus.GaugeValue
+		if metricConfig.Type == "counter" {
+			valueType = prometheus.CounterValue
+		}
+		metrics = append(metrics, customMetric{
+			CustomMetric: metricConfig,
+			desc:         prometheus.NewDesc(prometheus.BuildFQName(namespace, "", metricConfig.Name), help, labelNames, nil),
+			valueType:    valueType,
+		})
+	}
+	return &CustomCollector{
+		source:    source,
+		inventory: inventory,
+		filters:   filters,
+		logger:    logger,
+		metrics:   metrics,
+	}
+}
+
+func (c *CustomCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, metric := range c.metrics {
+		ch <- metric.desc
+	}
+	ch <- filteredObjectsDesc
+}
+
+func (c *CustomCollector) Collect(ch chan<- prometheus.Metric) {
+	// the metrics of an object type share one retrieval of all their properties
+	var objectTypes []string
+	metricsByObject := map[string][]customMetric{}
+	pathsByObject := map[string][]string{}
+	for _, metric := range c.metrics {
+		if _, ok := metricsByObject[metric.Object]; !ok {
+			objectTypes = append(objectTypes, metric.Object)
+		}
+		metricsByObject[metric.Object] = append(metricsByObject[metric.Object], metric)
+		pathsByObject[metric.Object] = appendPaths(pathsByObject[metric.Object], metric.CustomMetric)
+	}
+	for _, objectType := range objectTypes {
+		for _, path := range filterProperties[objectType] {
+			if !containsString(pathsByObject[objectType], path) {
+				pathsByObject[objectType] = append(pathsByObject[objectType], path)
+			}
+		}
+	}
+
+	for _, objectType := range objectTypes {
+		objectList, err := c.source.RetrieveProperties(objectType, pathsByObject[objectType])
+		if err != nil {
+			c.logger.Error("error when retrieving the custom metric properties", "object", objectType, "err", err)
+			continue
+		}
+		filter := newObjectFilter("custom", objectType, c.filters, c.source, c.inventory, c.logger)
+		for _, object := range objectList {
+			properties := map[string]interface{}{}
+			for _, property := range object.PropSet {
+				properties[property.Name] = property.Val
+			}
+			if !filter.keepProperties(object.Obj, properties) {
+				continue
+			}
+			objectName := c.inventory.Name(object.Obj)
+			for _, metric := range metricsByObject[objectType] {
+				value, ok := customMetricValue(properties[metric.Property], metric.ValueMapping)
+				if !ok {
+					c.logger.Debug("no value of custom metric", "metric", metric.Name, "name", objectName, "property", metric.Property, "value", properties[metric.Property])
+					continue
+				}
+				labelValues := []string{objectName}
+				for _, label := range metric.Labels {
+					labelValues = append(labelValues, c.customLabelValue(properties[label.Property]))
+				}
+				ch <- prometheus.MustNewConstMetric(metric.desc, metric.valueType, value, labelValues...)
+			}
+		}
+		if _, ok := filterObjectTypes[objectType]; ok {
+			filter.collect(ch)
+		}
+	}
+}
+
+// customMetricValue converts a property to the value of a metric. Numbers are exported as is, times as unix seconds,
+// booleans as 1 and 0 unless mapped, strings and enums by the mapping or as numbers if they parse as one.
+func customMetricValue(property interface{}, mapping map[string]float64) (float64, bool) {
+	if property == nil {
+		return 0, false
+	}
+	if t, ok := property.(time.Time); ok {
+		return float64(t.UnixNano()) / 1e9, true
+	}
+	v := reflect.ValueOf(property)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return 0, false
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if value, ok := mapping[strconv.FormatBool(v.Bool())]; ok {
+			return value, true
+		}
+		if v.Bool() {
+			return 1, true
+		}
+		return 0, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String:
+		if value, ok := mapping[v.String()]; ok {
+			return value, true
+		}
+		if value, err := strconv.ParseFloat(v.String(), 64); err == nil {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+// customLabelValue converts a property to the value of a label, references are replaced by the name of the object
+func (c *CustomCollector) customLabelValue(property interface{}) string {
+	switch property := property.(type) {
+	case nil:
+		return ""
+	case types.ManagedObjectReference:
+		return c.inventory.Name(property)
+	case *types.ManagedObjectReference:
+		return c.inventory.Name(*property)
+	case time.Time:
+		return property.UTC().Format(time.RFC3339)
+	}
+	v := reflect.ValueOf(property)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return fmt.Sprint(v.Interface())
+	}
+	return ""
+}
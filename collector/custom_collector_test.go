@@ -0,0 +1,97 @@
+package collector
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/jenningsloy318/vsphere_exporter/logging"
+	"github.com/jenningsloy318/vsphere_exporter/vmware"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestCustomCollector(t *testing.T) {
+	model := simulator.ESX()
+	target := startTestModel(t, model, nil)
+
+	SetCustomMetrics([]config.CustomMetric{
+		{
+			Name:     "host_cpu_cores",
+			Help:     "number of physical cpu cores",
+			Object:   "HostSystem",
+			Property: "summary.hardware.numCpuCores",
+			Labels:   []config.CustomLabel{{Name: "vendor", Property: "summary.hardware.vendor"}},
+		},
+		{
+			Name:         "vm_powered_on",
+			Object:       "VirtualMachine",
+			Property:     "runtime.powerState",
+			Labels:       []config.CustomLabel{{Name: "host", Property: "runtime.host"}},
+			ValueMapping: map[string]float64{"poweredOn": 1, "poweredOff": 0},
+		},
+		{
+			Name:     "vm_template",
+			Object:   "VirtualMachine",
+			Property: "config.template",
+		},
+	})
+	defer SetCustomMetrics(nil)
+
+	source, err := vmware.NewSource(context.Background(), target, &config.ClusterConfig{Username: "user", Password: "pass", ConnectTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("Error when connecting to the simulator, %v", err)
+	}
+	defer source.Logout()
+	inventory := vmware.NewInventory(source)
+	if err := inventory.Load(); err != nil {
+		t.Fatalf("Error when loading the inventory, %v", err)
+	}
+
+	collector := NewCustomCollector(namespace, source, inventory, nil, logging.Logger())
+	expected := `
+# HELP vsphere_host_cpu_cores number of physical cpu cores
+# TYPE vsphere_host_cpu_cores gauge
+vsphere_host_cpu_cores{name="localhost.localdomain",vendor="VMware, Inc."} 2
+# HELP vsphere_vm_powered_on runtime.powerState of VirtualMachine
+# TYPE vsphere_vm_powered_on gauge
+vsphere_vm_powered_on{host="localhost.localdomain",name="ha-host_VM0"} 1
+vsphere_vm_powered_on{host="localhost.localdomain",name="ha-host_VM1"} 1
+# HELP vsphere_vm_template config.template of VirtualMachine
+# TYPE vsphere_vm_template gauge
+vsphere_vm_template{name="ha-host_VM0"} 0
+vsphere_vm_template{name="ha-host_VM1"} 0
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "vsphere_host_cpu_cores", "vsphere_vm_powered_on", "vsphere_vm_template"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCustomMetricValue(t *testing.T) {
+	mapping := map[string]float64{"poweredOn": 1, "true": 2}
+	tests := []struct {
+		property interface{}
+		value    float64
+		ok       bool
+	}{
+		{int32(4), 4, true},
+		{int64(-1), -1, true},
+		{float32(0.5), 0.5, true},
+		{false, 0, true},
+		{true, 2, true},
+		{types.VirtualMachinePowerStatePoweredOn, 1, true},
+		{types.VirtualMachinePowerStateSuspended, 0, false},
+		{"42", 42, true},
+		{time.Unix(1600000000, 0), 1600000000, true},
+		{nil, 0, false},
+		{types.ManagedObjectReference{}, 0, false},
+	}
+	for _, test := range tests {
+		if value, ok := customMetricValue(test.property, mapping); value != test.value || ok != test.ok {
+			t.Errorf("customMetricValue(%v) = %v, %v, expected %v, %v", test.property, value, ok, test.value, test.ok)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package collector
+
+import (
+	"log/slog"
+
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/jenningsloy318/vsphere_exporter/vmware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+var (
+	filteredObjectsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, subsystem, "filtered_objects"),
+		"number of objects no metrics are exported for because of the filters or being a template",
+		[]string{"collector", "type"}, nil,
+	)
+
+	// filterObjectTypes are the filters of the managed object types
+	filterObjectTypes = map[string]string{
+		"VirtualMachine": "vm",
+		"HostSystem":     "host",
+		"Datastore":      "datastore",
+	}
+	// filterProperties are the properties the filters need, by managed object type
+	filterProperties = map[string][]string{
+		"VirtualMachine": {"config.template", "runtime.powerState", "runtime.host", "resourcePool"},
+		"HostSystem":     {"runtime.powerState"},
+	}
+)
+
+// objectFilter selects the objects of a managed object type a collector exports metrics for, and counts the others
+type objectFilter struct {
+	collector  string
+	objectType string
+	filter     *config.FilterConfig
+	source     vmware.Source
+	inventory  *vmware.Inventory
+	logger     *slog.Logger
+	tags       map[types.ManagedObjectReference][]string
+	filtered   int
+}
+
+// newObjectFilter returns the filter of the managed object type, e.g. VirtualMachine, among the filters keyed by "vm", "host" or "datastore"
+func newObjectFilter(collector string, objectType string, filters map[string]*config.FilterConfig, source vmware.Source, inventory *vmware.Inventory, logger *slog.Logger) *objectFilter {
+	return &objectFilter{
+		collector:  collector,
+		objectType: objectType,
+		filter:     filters[filterObjectTypes[objectType]],
+		source:     source,
+		inventory:  inventory,
+		logger:     logger,
+	}
+}
+
+// keep reports whether metrics are exported for the object, its name, path, tags and, unless given, its cluster come from the inventory
+func (f *objectFilter) keep(ref types.ManagedObjectReference, object config.FilterObject) bool {
+	object.Name = f.inventory.Name(ref)
+	object.Path = f.inventory.Path(ref)
+	if object.Cluster == "" {
+		if cluster, ok := f.inventory.Ancestor(ref, "ClusterComputeResource"); ok {
+			object.Cluster = f.inventory.Name(cluster)
+		}
+	}
+	if f.filter.UsesTags() {
+		if f.tags == nil {
+			var err error
+			if f.tags, err = f.source.ListTags(); err != nil {
+				f.logger.Error("error when retrieving the tags of the filters", "err", err)
+				f.tags = map[types.ManagedObjectReference][]string{}
+			}
+		}
+		object.Tags = f.tags[ref]
+	}
+	if f.filter.Keep(object) {
+		return true
+	}
+	f.filtered++
+	return false
+}
+
+// keepVM reports whether metrics are exported for the vm, vms aren't below their cluster in the inventory, so it is the cluster of their host
+func (f *objectFilter) keepVM(ref types.ManagedObjectReference, host *types.ManagedObjectReference, resourcePool *types.ManagedObjectReference, powerState types.VirtualMachinePowerState, template bool) bool {
+	object := config.FilterObject{PowerState: string(powerState), Template: template}
+	if resourcePool != nil {
+		object.ResourcePool = f.inventory.Name(*resourcePool)
+	}
+	if host != nil {
+		if cluster, ok := f.inventory.Ancestor(*host, "ClusterComputeResource"); ok {
+			object.Cluster = f.inventory.Name(cluster)
+		}
+	}
+	return f.keep(ref, object)
+}
+
+// keepProperties reports whether metrics are exported for the object, given its properties retrieved with filterProperties
+func (f *objectFilter) keepProperties(ref types.ManagedObjectReference, properties map[string]interface{}) bool {
+	switch f.objectType {
+	case "VirtualMachine":
+		template, _ := properties["config.template"].(bool)
+		powerState, _ := properties["runtime.powerState"].(types.VirtualMachinePowerState)
+		var host, resourcePool *types.ManagedObjectReference
+		if ref, ok := properties["runtime.host"].(types.ManagedObjectReference); ok {
+			host = &ref
+		}
+		if ref, ok := properties["resourcePool"].(types.ManagedObjectReference); ok {
+			resourcePool = &ref
+		}
+		return f.keepVM(ref, host, resourcePool, powerState, template)
+	case "HostSystem":
+		powerState, _ := properties["runtime.powerState"].(types.HostSystemPowerState)
+		return f.keep(ref, config.FilterObject{PowerState: string(powerState)})
+	case "Datastore":
+		return f.keep(ref, config.FilterObject{})
+	}
+	return true
+}
+
+// collect sends the number of filtered objects
+func (f *objectFilter) collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(filteredObjectsDesc, prometheus.GaugeValue, float64(f.filtered), f.collector, f.objectType)
+}
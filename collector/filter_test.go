@@ -0,0 +1,219 @@
+package collector
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vapi/rest"
+	_ "github.com/vmware/govmomi/vapi/simulator"
+	"github.com/vmware/govmomi/vapi/tags"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// newFilterTestVCenter starts a vCenter with a standalone and a clustered host running two vms each,
+// DC0_H0_VM1 is a template and DC0_H0_VM0 and DC0_C0_RP0_VM0 are tagged env/prod
+func newFilterTestVCenter(t *testing.T) string {
+	t.Helper()
+	model := simulator.VPX()
+	model.Host = 1
+	model.ClusterHost = 1
+	var vms map[string]types.ManagedObjectReference
+	target := startTestModel(t, model, func(registry *simulator.Registry) {
+		// serves the REST API the tags are managed with
+		model.Service.RegisterEndpoints = true
+		vms = map[string]types.ManagedObjectReference{}
+		for _, entity := range registry.All("VirtualMachine") {
+			vm := entity.(*simulator.VirtualMachine)
+			vm.Summary.QuickStats.UptimeSeconds = 3600
+			if vm.Name == "DC0_H0_VM1" {
+				vm.Config.Template = true
+			}
+			vms[vm.Name] = vm.Self
+		}
+	})
+
+	ctx := context.Background()
+	client, err := govmomi.NewClient(ctx, &url.URL{Scheme: "https", Host: target, Path: "/sdk", User: url.UserPassword("user", "pass")}, true)
+	if err != nil {
+		t.Fatalf("Error when connecting to the simulator, %v", err)
+	}
+	restClient := rest.NewClient(client.Client)
+	if err := restClient.Login(ctx, url.UserPassword("user", "pass")); err != nil {
+		t.Fatalf("Error when logging into the simulator REST API, %v", err)
+	}
+	tagManager := tags.NewManager(restClient)
+	categoryID, err := tagManager.CreateCategory(ctx, &tags.Category{Name: "env", Cardinality: "SINGLE", AssociableTypes: []string{"VirtualMachine"}})
+	if err != nil {
+		t.Fatalf("Error when creating tag category, %v", err)
+	}
+	tagID, err := tagManager.CreateTag(ctx, &tags.Tag{Name: "prod", CategoryID: categoryID})
+	if err != nil {
+		t.Fatalf("Error when creating tag, %v", err)
+	}
+	if err := tagManager.AttachTagToMultipleObjects(ctx, tagID, []mo.Reference{vms["DC0_H0_VM0"], vms["DC0_C0_RP0_VM0"]}); err != nil {
+		t.Fatalf("Error when attaching tag, %v", err)
+	}
+	return target
+}
+
+func TestFilters(t *testing.T) {
+	target := newFilterTestVCenter(t)
+	tests := []struct {
+		name    string
+		filters string
+		// clusterFilters are the filters of the default credentials the target resolves to
+		clusterFilters string
+		vms            []string
+		hosts          []string
+		filtered       map[string]float64
+	}{
+		{
+			name:     "templates excluded by default",
+			vms:      []string{"DC0_C0_RP0_VM0", "DC0_C0_RP0_VM1", "DC0_H0_VM0"},
+			hosts:    []string{"DC0_C0_H0", "DC0_H0"},
+			filtered: map[string]float64{"vm": 1, "host": 0},
+		},
+		{
+			name: "templates kept",
+			filters: `
+  vm:
+    templates: true`,
+			vms:      []string{"DC0_C0_RP0_VM0", "DC0_C0_RP0_VM1", "DC0_H0_VM0", "DC0_H0_VM1"},
+			hosts:    []string{"DC0_C0_H0", "DC0_H0"},
+			filtered: map[string]float64{"vm": 0, "host": 0},
+		},
+		{
+			name: "included tag",
+			filters: `
+  vm:
+    include:
+      tags: [prod]`,
+			vms:      []string{"DC0_C0_RP0_VM0", "DC0_H0_VM0"},
+			hosts:    []string{"DC0_C0_H0", "DC0_H0"},
+			filtered: map[string]float64{"vm": 2, "host": 0},
+		},
+		{
+			name: "excluded cluster and name",
+			filters: `
+  vm:
+    exclude:
+      clusters: [DC0_C0]
+  host:
+    exclude:
+      names: [DC0_H.*]`,
+			vms:      []string{"DC0_H0_VM0"},
+			hosts:    []string{"DC0_C0_H0"},
+			filtered: map[string]float64{"vm": 3, "host": 1},
+		},
+		{
+			name: "included folder and power state",
+			filters: `
+  vm:
+    include:
+      folders: [/DC0/vm]
+      power_states: [poweredOn]
+  host:
+    include:
+      folders: [/DC0/host/DC0_C0]`,
+			vms:      []string{"DC0_C0_RP0_VM0", "DC0_C0_RP0_VM1", "DC0_H0_VM0"},
+			hosts:    []string{"DC0_C0_H0"},
+			filtered: map[string]float64{"vm": 1, "host": 1},
+		},
+		{
+			name: "vm filter overridden by the cluster",
+			filters: `
+  vm:
+    include:
+      tags: [prod]
+  host:
+    exclude:
+      names: [DC0_H.*]`,
+			clusterFilters: `
+      vm:
+        templates: true`,
+			vms:      []string{"DC0_C0_RP0_VM0", "DC0_C0_RP0_VM1", "DC0_H0_VM0", "DC0_H0_VM1"},
+			hosts:    []string{"DC0_C0_H0"},
+			filtered: map[string]float64{"vm": 0, "host": 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configFile := filepath.Join(t.TempDir(), "config.yml")
+			configYAML := `
+clusters:
+  default:
+    username: user
+    password: pass
+    connect_timeout: 1s
+    filters:` + test.clusterFilters + `
+filters:` + test.filters + "\n"
+			if err := ioutil.WriteFile(configFile, []byte(configYAML), 0644); err != nil {
+				t.Fatal(err)
+			}
+			sc := &config.SafeConfig{}
+			if err := sc.ReloadConfig(configFile); err != nil {
+				t.Fatalf("Error when loading the filters, %v", err)
+			}
+			clusterConfig, _, err := sc.ResolveTarget(target)
+			if err != nil {
+				t.Fatalf("Error when resolving %s, %v", target, err)
+			}
+
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(NewVshpereCollector(context.Background(), target, clusterConfig))
+			metricFamilies, err := registry.Gather()
+			if err != nil {
+				t.Fatalf("Error when scraping %s, %v", target, err)
+			}
+			if vms := seriesLabelValues(metricFamilies, "vsphere_vm_uptime", "name"); !equalStrings(vms, test.vms) {
+				t.Errorf("expected vms %v, got %v", test.vms, vms)
+			}
+			if hosts := seriesLabelValues(metricFamilies, "vsphere_host_uptime", "hostname"); !equalStrings(hosts, test.hosts) {
+				t.Errorf("expected hosts %v, got %v", test.hosts, hosts)
+			}
+			filteredObjects := findMetricFamily(metricFamilies, "vsphere_exporter_filtered_objects")
+			if filteredObjects == nil {
+				t.Fatalf("expected the filtered objects")
+			}
+			for _, metric := range filteredObjects.GetMetric() {
+				collector := labelValue(metric, "collector")
+				if filtered := metric.GetGauge().GetValue(); filtered != test.filtered[collector] {
+					t.Errorf("expected %v objects filtered by the %s collector, got %v", test.filtered[collector], collector, filtered)
+				}
+			}
+		})
+	}
+}
+
+func seriesLabelValues(metricFamilies []*dto.MetricFamily, name string, label string) []string {
+	var values []string
+	if metricFamily := findMetricFamily(metricFamilies, name); metricFamily != nil {
+		for _, metric := range metricFamily.GetMetric() {
+			values = append(values, labelValue(metric, label))
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
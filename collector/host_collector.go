@@ -1,45 +1,69 @@
 package collector
 
 import (
-	"fmt"
+	"github.com/jenningsloy318/vsphere_exporter/config"
 	"github.com/jenningsloy318/vsphere_exporter/vmware"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/log"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"log/slog"
 	"strings"
+	"time"
 )
 
 var (
-	hostSubsystem  = "host"
-	hostMapping    = map[string]string{}
-	hostLabelNames = []string{"hostname", "os"}
+	hostSubsystem    = "host"
+	hostLabelNames   = []string{"hostname", "os"}
+	hostStateMetrics = map[string]stateMetric{
+		"host_connection_state": newStateMetric(
+			prometheus.BuildFQName(namespace, hostSubsystem, "connection_state"),
+			"host connection state to vcenter",
+			"host connection state to vcenter, 1 for active, 2 for activeDefer, 3 for armed, 4 for init,5 for down,6 for unkown",
+			hostLabelNames,
+			types.HostSystemConnectionState("").Strings(),
+		),
+		"host_power_state": newStateMetric(
+			prometheus.BuildFQName(namespace, hostSubsystem, "power_state"),
+			"host power state",
+			"host power state, 1 for poweron, 2 for poweroff, 3 for standby, 4 for unkown",
+			hostLabelNames,
+			types.HostSystemPowerState("").Strings(),
+		),
+		"host_standby_mode": newStateMetric(
+			prometheus.BuildFQName(namespace, hostSubsystem, "standby_mode"),
+			"host standby mode",
+			"host standby mode, 1 for in , 2 for exiting, 3 for entering, 4 for none",
+			hostLabelNames,
+			types.HostStandbyMode("").Strings(),
+		),
+		"host_overall_status": newStateMetric(
+			prometheus.BuildFQName(namespace, hostSubsystem, "overall_status"),
+			"host overall status",
+			"host overall status, 1 for green, 2 for yellow, 3 for gray, 4 for red",
+			hostLabelNames,
+			types.ManagedEntityStatus("").Strings(),
+		),
+		"host_network_stack_state": newStateMetric(
+			prometheus.BuildFQName(namespace, hostSubsystem, "network_statck_state"),
+			"network stack state",
+			"network stack state, 1 for active, 0 for inactive",
+			[]string{"hostname", "os", "component"},
+			types.HostRuntimeInfoNetStackInstanceRuntimeInfoState("").Strings(),
+		),
+	}
+	// health states of the sensors and hardware elements, vSphere reports them in different cases
+	hostHealthStates = []string{"unknown", "green", "yellow", "red"}
+	// hardware status metrics of the numeric sensor types reporting a single hardware element
+	hostSensorHardwareStatus = map[string]string{
+		"power":    "host_power_supply_hardware_status",
+		"fan":      "host_fan_hardware_status",
+		"battery":  "host_battery_hardware_status",
+		"watchdog": "host_watchdog_hardware_status",
+	}
+	// labels of the numeric sensors, the host labels followed by the sensor identity
+	hostSensorLabelNames = []string{"hostname", "os", "sensor", "sensor_id", "sensor_type"}
 	//hostLabelNames = []string{"category"}
 	hostMetrics = map[string]hostMetric{
-
-		"host_connection_state": {
-			desc: prometheus.NewDesc(
-				prometheus.BuildFQName(namespace, hostSubsystem, "connection_state"),
-				"host connection state to vcenter, 1 for active, 2 for activeDefer, 3 for armed, 4 for init,5 for down,6 for unkown",
-				hostLabelNames,
-				nil,
-			),
-		},
-		"host_power_state": {
-			desc: prometheus.NewDesc(
-				prometheus.BuildFQName(namespace, hostSubsystem, "power_state"),
-				"host power state, 1 for poweron, 2 for poweroff, 3 for standby, 4 for unkown",
-				hostLabelNames,
-				nil,
-			),
-		},
-
-		"host_standby_mode": {
-			desc: prometheus.NewDesc(
-				prometheus.BuildFQName(namespace, hostSubsystem, "standby_mode"),
-				"host standby mode, 1 for in , 2 for exiting, 3 for entering, 4 for none",
-				hostLabelNames,
-				nil,
-			),
-		},
 		"host_maintenance_mode": {
 			desc: prometheus.NewDesc(
 				prometheus.BuildFQName(namespace, hostSubsystem, "maintenance_mode"),
@@ -120,14 +144,6 @@ var (
 				nil,
 			),
 		},
-		"host_overall_status": {
-			desc: prometheus.NewDesc(
-				prometheus.BuildFQName(namespace, hostSubsystem, "overall_status"),
-				"host overall status, 1 for green, 2 for yellow, 3 for gray, 4 for red",
-				hostLabelNames,
-				nil,
-			),
-		},
 		"host_memory_size": {
 			desc: prometheus.NewDesc(
 				prometheus.BuildFQName(namespace, hostSubsystem, "memory_size"),
@@ -168,6 +184,118 @@ var (
 				nil,
 			),
 		},
+		"host_sensor_celsius": {
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, hostSubsystem, "sensor_celsius"),
+				"host temperature sensor reading in degrees celsius",
+				hostSensorLabelNames,
+				nil,
+			),
+		},
+		"host_sensor_rpm": {
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, hostSubsystem, "sensor_rpm"),
+				"host fan sensor reading in revolutions per minute",
+				hostSensorLabelNames,
+				nil,
+			),
+		},
+		"host_sensor_volts": {
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, hostSubsystem, "sensor_volts"),
+				"host voltage sensor reading in volts",
+				hostSensorLabelNames,
+				nil,
+			),
+		},
+		"host_sensor_amperes": {
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, hostSubsystem, "sensor_amperes"),
+				"host current sensor reading in amperes",
+				hostSensorLabelNames,
+				nil,
+			),
+		},
+		"host_sensor_watts": {
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, hostSubsystem, "sensor_watts"),
+				"host power sensor reading in watts",
+				hostSensorLabelNames,
+				nil,
+			),
+		},
+		"host_sensor_health_state": {
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, hostSubsystem, "sensor_health_state"),
+				"host sensor health state, 1 for the current state and 0 for the others",
+				[]string{"hostname", "os", "sensor", "sensor_id", "sensor_type", "state"},
+				nil,
+			),
+		},
+		"host_memory_hardware_status": {
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, hostSubsystem, "memory_hardware_status"),
+				"memory hardware status, 1 for the current state of unknown, green, yellow and red, 0 for the others",
+				[]string{"hostname", "os", "component", "state"},
+				nil,
+			),
+		},
+		"host_cpu_hardware_status": {
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, hostSubsystem, "cpu_hardware_status"),
+				"cpu hardware status, 1 for the current state of unknown, green, yellow and red, 0 for the others",
+				[]string{"hostname", "os", "component", "state"},
+				nil,
+			),
+		},
+		"host_storage_hardware_status": {
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, hostSubsystem, "storage_hardware_status"),
+				"storage hardware status, 1 for the current state of unknown, green, yellow and red, 0 for the others",
+				[]string{"hostname", "os", "component", "state"},
+				nil,
+			),
+		},
+		"host_power_supply_hardware_status": {
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, hostSubsystem, "power_supply_hardware_status"),
+				"power supply hardware status, 1 for the current state of unknown, green, yellow and red, 0 for the others",
+				[]string{"hostname", "os", "component", "state"},
+				nil,
+			),
+		},
+		"host_fan_hardware_status": {
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, hostSubsystem, "fan_hardware_status"),
+				"fan hardware status, 1 for the current state of unknown, green, yellow and red, 0 for the others",
+				[]string{"hostname", "os", "component", "state"},
+				nil,
+			),
+		},
+		"host_battery_hardware_status": {
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, hostSubsystem, "battery_hardware_status"),
+				"battery hardware status, 1 for the current state of unknown, green, yellow and red, 0 for the others",
+				[]string{"hostname", "os", "component", "state"},
+				nil,
+			),
+		},
+		"host_watchdog_hardware_status": {
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, hostSubsystem, "watchdog_hardware_status"),
+				"watchdog hardware status, 1 for the current state of unknown, green, yellow and red, 0 for the others",
+				[]string{"hostname", "os", "component", "state"},
+				nil,
+			),
+		},
+		"host_other_hardware_status": {
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, hostSubsystem, "other_hardware_status"),
+				"status of other hardware elements reported by the host sensors, 1 for the current state of unknown, green, yellow and red, 0 for the others",
+				[]string{"hostname", "os", "component", "type", "state"},
+				nil,
+			),
+		},
 		"host_hba_counts": {
 			desc: prometheus.NewDesc(
 				prometheus.BuildFQName(namespace, hostSubsystem, "hba_counts"),
@@ -176,13 +304,47 @@ var (
 				nil,
 			),
 		},
+		"host_pnic_available_bandwidth_for_vm_traffic": {
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, hostSubsystem, "pnic_available_bandwidth_for_vm_traffic"),
+				"pnic_available_bandwidth_for_vm_traffic",
+				[]string{"hostname", "os", "component"},
+				nil,
+			),
+		},
+		"host_pnic_unused_bandwidth_for_vm_traffic": {
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, hostSubsystem, "pnic_unused_bandwidth_for_vm_traffic"),
+				"pnic_unused_bandwidth_for_vm_traffic",
+				[]string{"hostname", "os", "component"},
+				nil,
+			),
+		},
 	}
 )
 
+func init() {
+	registerCollector("host", true, map[string][]string{
+		"HostSystem":             {"summary", "runtime", "hardware", "config", "capability", "configManager"},
+		"HostHealthStatusSystem": {"runtime"},
+	}, []string{
+		"System.View",
+		"System.Read",
+		// the health of the hosts is only readable with Host.Config.Settings
+		"Host.Config.Settings",
+	}, func(namespace string, source vmware.Source, inventory *vmware.Inventory, filters map[string]*config.FilterConfig, logger *slog.Logger) prometheus.Collector {
+		return NewHostCollector(namespace, source, inventory, filters, logger)
+	})
+}
+
 // A HostCollector implements the prometheus.Collector.
 type HostCollector struct {
-	vsClient              *vmware.VMClient
+	source                vmware.Source
+	inventory             *vmware.Inventory
+	filters               map[string]*config.FilterConfig
+	logger                *slog.Logger
 	metrics               map[string]hostMetric
+	stateMetrics          map[string]stateMetric
 	collectorScrapeStatus *prometheus.GaugeVec
 }
 
@@ -191,13 +353,17 @@ type hostMetric struct {
 }
 
 // NewHostCollector returns a collector that collecting host statistics
-func NewHostCollector(namespace string, vsClient *vmware.VMClient) *HostCollector {
+func NewHostCollector(namespace string, source vmware.Source, inventory *vmware.Inventory, filters map[string]*config.FilterConfig, logger *slog.Logger) *HostCollector {
 
 	// get service from redfish client
 
 	return &HostCollector{
-		vsClient: vsClient,
-		metrics:  hostMetrics,
+		source:       source,
+		inventory:    inventory,
+		filters:      filters,
+		logger:       logger,
+		metrics:      hostMetrics,
+		stateMetrics: hostStateMetrics,
 		collectorScrapeStatus: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Namespace: namespace,
@@ -213,35 +379,46 @@ func (h *HostCollector) Describe(ch chan<- *prometheus.Desc) {
 	for _, metric := range h.metrics {
 		ch <- metric.desc
 	}
+	for _, metric := range h.stateMetrics {
+		metric.describe(ch)
+	}
 	h.collectorScrapeStatus.Describe(ch)
+	ch <- filteredObjectsDesc
 
 }
 
 func (h *HostCollector) Collect(ch chan<- prometheus.Metric) {
 	// get a host list from vsphere client
-	if hostList, err := h.vsClient.ListHost(); err != nil {
-		log.Infof("Errors Getting host list from vsphere : %s", err)
+	if hostList, err := h.source.ListHost(); err != nil {
+		h.logger.Error("Errors Getting host list from vsphere", "err", err)
 	} else {
+		// the filtered hosts are dropped before their health is retrieved
+		filter := newObjectFilter("host", "HostSystem", h.filters, h.source, h.inventory, h.logger)
+		var keptHostList []mo.HostSystem
+		for _, host := range hostList {
+			if filter.keep(host.Self, config.FilterObject{PowerState: string(host.Runtime.PowerState)}) {
+				keptHostList = append(keptHostList, host)
+			}
+		}
+		hostList = keptHostList
+		filter.collect(ch)
+
+		h.fillHealthSystemRuntime(hostList)
 		// process the host status
 		for _, host := range hostList {
 			hostSummary := host.Summary
 			hostRumtime := host.Runtime
-			hostName := hostSummary.Config.Name
-			hostID := host.ManagedEntity.ExtensibleManagedObject.Self.Value
-			hostMapping[hostID] = hostName
+			hostName := h.inventory.Name(host.Self)
 			esxiFullName := hostSummary.Config.Product.FullName
 			hostLabelValues := []string{hostName, esxiFullName}
 
 			// retrieve the connection state between host and vcenter
-			hostConnectionStateValue := parseConnectionState(hostRumtime.ConnectionState)
-			ch <- prometheus.MustNewConstMetric(h.metrics["host_connection_state"].desc, prometheus.GaugeValue, hostConnectionStateValue, hostLabelValues...)
+			h.stateMetrics["host_connection_state"].collect(ch, string(hostRumtime.ConnectionState), parseConnectionState(hostRumtime.ConnectionState), hostLabelValues...)
 
 			// retrueve the powerstate
-			hostPowerStateValue := parsePowerState(hostRumtime.PowerState)
-			ch <- prometheus.MustNewConstMetric(h.metrics["host_power_state"].desc, prometheus.GaugeValue, hostPowerStateValue, hostLabelValues...)
+			h.stateMetrics["host_power_state"].collect(ch, string(hostRumtime.PowerState), parsePowerState(hostRumtime.PowerState), hostLabelValues...)
 			// retrueve standby mode
-			hostStandbyModeValue := parseHostStandbyMode(hostRumtime.StandbyMode)
-			ch <- prometheus.MustNewConstMetric(h.metrics["host_standby_mode"].desc, prometheus.GaugeValue, hostStandbyModeValue, hostLabelValues...)
+			h.stateMetrics["host_standby_mode"].collect(ch, hostRumtime.StandbyMode, parseHostStandbyMode(hostRumtime.StandbyMode), hostLabelValues...)
 
 			// retrieve the maintenance mode
 			var hostMaintenanceModeValue float64
@@ -254,113 +431,69 @@ func (h *HostCollector) Collect(ch chan<- prometheus.Metric) {
 
 			// retrieve the in quarantine  mode
 			var hostInQuarantineModeValue float64
-			if *hostRumtime.InQuarantineMode {
+			if hostRumtime.InQuarantineMode != nil && *hostRumtime.InQuarantineMode {
 				hostInQuarantineModeValue = float64(1)
 			} else {
 				hostInQuarantineModeValue = float64(0)
 			}
 			ch <- prometheus.MustNewConstMetric(h.metrics["host_in_quarantine_mode"].desc, prometheus.GaugeValue, hostInQuarantineModeValue, hostLabelValues...)
 
-			healthSystemRuntime := hostRumtime.HealthSystemRuntime
-
-			systemHealthInfo := healthSystemRuntime.SystemHealthInfo
-			hardwareStatusInfo := healthSystemRuntime.HardwareStatusInfo
+			var systemHealthInfo types.HostSystemHealthInfo
+			var hardwareStatusInfo types.HostHardwareStatusInfo
+			if healthSystemRuntime := hostRumtime.HealthSystemRuntime; healthSystemRuntime != nil {
+				if healthSystemRuntime.SystemHealthInfo != nil {
+					systemHealthInfo = *healthSystemRuntime.SystemHealthInfo
+				}
+				if healthSystemRuntime.HardwareStatusInfo != nil {
+					hardwareStatusInfo = *healthSystemRuntime.HardwareStatusInfo
+				}
+			}
 
 			for _, hostNumericSensorInfo := range systemHealthInfo.NumericSensorInfo {
-				sensorName := hostNumericSensorInfo.Name
-				sensorID := hostNumericSensorInfo.Id
-				sensorType := hostNumericSensorInfo.SensorType
-				sensorCurrentValue := float64(hostNumericSensorInfo.CurrentReading)
-				sensorDescriptionKey := hostNumericSensorInfo.HealthState.GetElementDescription().Key
-				sensorDescription := hostNumericSensorInfo.HealthState.GetElementDescription().Description
-				sensorDescriptionSummary := sensorDescription.Summary
-				sensorDescriptionLabel := sensorDescription.Label
-				sensorTimeStamp := hostNumericSensorInfo.TimeStamp
-				fmt.Printf("sensorDescriptionKey:%s,sensorDescriptionSummary:%s,sensorDescriptionLabel:%s\n", sensorDescriptionKey, sensorDescriptionSummary, sensorDescriptionLabel)
-				metricLabelNames := append(hostLabelNames, "sensorName", "sensorID", "sensorType", "Time")
-				metricLabelValues := append(hostLabelValues, sensorName, sensorID, sensorType, sensorTimeStamp)
-				metricName := fmt.Sprintf("%s_sensor_state", strings.ToLower(strings.ReplaceAll(sensorType, " ", "_")))
-
-				sensorDesc := prometheus.NewDesc(
-					prometheus.BuildFQName(namespace, hostSubsystem, metricName),
-					sensorDescriptionSummary,
-					metricLabelNames,
-					nil,
-				)
-				ch <- prometheus.MustNewConstMetric(sensorDesc, prometheus.GaugeValue, sensorCurrentValue, metricLabelValues...)
-
-			}
+				metricLabelValues := append(hostLabelValues, hostNumericSensorInfo.Name, hostNumericSensorInfo.Id, hostNumericSensorInfo.SensorType)
+				var sensorMetrics []prometheus.Metric
 
-			memoryStatusInfo := hardwareStatusInfo.MemoryStatusInfo
-
-			for _, memoryStatusInfoItem := range memoryStatusInfo {
-				memoryStatusInfoData := memoryStatusInfoItem.GetHostHardwareElementInfo()
-				memoryStatusName := memoryStatusInfoData.Name
-				memoryStatusDescription := memoryStatusInfoData.Status.GetElementDescription()
-				metricLabelNames := append(hostLabelNames, "component")
-				metricLabelValues := append(hostLabelValues, memoryStatusName)
-				memoryHWDesc := prometheus.NewDesc(
-					prometheus.BuildFQName(namespace, hostSubsystem, "memory_hardware_status"),
-					"memory hardware status, 0 for green, 1 for red",
-					metricLabelNames,
-					nil,
-				)
-				var memoryHWstateValue float64
-				if memoryStatusDescription.Key == "Green" {
-					memoryHWstateValue = float64(0)
-				} else {
-					memoryHWstateValue = float64(1)
+				// retrieve the reading, scaled by the unit modifier, for sensors with a known unit
+				if metricName, sensorValue, ok := parseSensorReading(hostNumericSensorInfo); ok {
+					sensorMetrics = append(sensorMetrics, prometheus.MustNewConstMetric(h.metrics[metricName].desc, prometheus.GaugeValue, sensorValue, metricLabelValues...))
 				}
-				ch <- prometheus.MustNewConstMetric(memoryHWDesc, prometheus.GaugeValue, memoryHWstateValue, metricLabelValues...)
 
-			}
-			cpuStatusInfo := hardwareStatusInfo.CpuStatusInfo
-			for _, cpuStatusInfoItem := range cpuStatusInfo {
-				cpuStatusInfoData := cpuStatusInfoItem.GetHostHardwareElementInfo()
-				cpuStatusName := cpuStatusInfoData.Name
-				cpuStatusDescription := cpuStatusInfoData.Status.GetElementDescription()
-				metricLabelNames := append(hostLabelNames, "component")
-				metricLabelValues := append(hostLabelValues, cpuStatusName)
-				cpuHWDesc := prometheus.NewDesc(
-					prometheus.BuildFQName(namespace, hostSubsystem, "cpu_hardware_status"),
-					"cpu hardware status, 0 for green, 1 for red",
-					metricLabelNames,
-					nil,
-				)
-				var cpuHWstateValue float64
-				if cpuStatusDescription.Key == "Green" {
-					cpuHWstateValue = float64(0)
-				} else {
-					cpuHWstateValue = float64(1)
+				// retrieve the health state of the sensor
+				sensorHealthState := parseHealthState(hostNumericSensorInfo.HealthState)
+				sensorMetrics = append(sensorMetrics, newStateSetMetrics(h.metrics["host_sensor_health_state"].desc, hostHealthStates, sensorHealthState, metricLabelValues...)...)
+
+				// the sensors of power supplies, fans, batteries, watchdogs and other hardware elements also report the status of their element
+				switch sensorType := strings.ToLower(hostNumericSensorInfo.SensorType); sensorType {
+				case "temperature", "voltage":
+				case "power", "fan", "battery", "watchdog":
+					sensorMetrics = append(sensorMetrics, newStateSetMetrics(h.metrics[hostSensorHardwareStatus[sensorType]].desc, hostHealthStates, sensorHealthState, append(hostLabelValues, hostNumericSensorInfo.Name)...)...)
+				default:
+					sensorMetrics = append(sensorMetrics, newStateSetMetrics(h.metrics["host_other_hardware_status"].desc, hostHealthStates, sensorHealthState, append(hostLabelValues, hostNumericSensorInfo.Name, hostNumericSensorInfo.SensorType)...)...)
 				}
-				ch <- prometheus.MustNewConstMetric(cpuHWDesc, prometheus.GaugeValue, cpuHWstateValue, metricLabelValues...)
-
-			}
 
-			StorageStatusInfo := hardwareStatusInfo.StorageStatusInfo
-			for _, storageStatusInfoItem := range StorageStatusInfo {
-				storageStatusName := storageStatusInfoItem.HostHardwareElementInfo.Name
-				storageStatusDescription := storageStatusInfoItem.HostHardwareElementInfo.Status.GetElementDescription()
-
-				metricLabelNames := append(hostLabelNames, "component")
-				metricLabelValues := append(hostLabelValues, storageStatusName)
-				storageHWDesc := prometheus.NewDesc(
-					prometheus.BuildFQName(namespace, hostSubsystem, "storage_hardware_status"),
-					"storage hardware status, 0 for green, 1 for red",
-					metricLabelNames,
-					nil,
-				)
-				var storageHWstateValue float64
-				if storageStatusDescription.Key == "Green" {
-					storageHWstateValue = float64(0)
-				} else {
-					storageHWstateValue = float64(1)
+				// the sensor time stamp is the sample time of the reading
+				sensorTimeStamp, err := time.Parse(time.RFC3339, hostNumericSensorInfo.TimeStamp)
+				for _, sensorMetric := range sensorMetrics {
+					if err == nil {
+						sensorMetric = prometheus.NewMetricWithTimestamp(sensorTimeStamp, sensorMetric)
+					}
+					ch <- sensorMetric
 				}
-				ch <- prometheus.MustNewConstMetric(storageHWDesc, prometheus.GaugeValue, storageHWstateValue, metricLabelValues...)
+			}
 
+			// retrieve the status of the memory, cpu and storage elements
+			h.collectHardwareStatus(ch, "host_memory_hardware_status", hardwareStatusInfo.MemoryStatusInfo, hostLabelValues)
+			h.collectHardwareStatus(ch, "host_cpu_hardware_status", hardwareStatusInfo.CpuStatusInfo, hostLabelValues)
+			storageStatusInfo := make([]types.BaseHostHardwareElementInfo, 0, len(hardwareStatusInfo.StorageStatusInfo))
+			for i := range hardwareStatusInfo.StorageStatusInfo {
+				storageStatusInfo = append(storageStatusInfo, &hardwareStatusInfo.StorageStatusInfo[i])
 			}
+			h.collectHardwareStatus(ch, "host_storage_hardware_status", storageStatusInfo, hostLabelValues)
 
-			networkRuntimeInfo := hostRumtime.NetworkRuntimeInfo
+			var networkRuntimeInfo types.HostRuntimeInfoNetworkRuntimeInfo
+			if hostRumtime.NetworkRuntimeInfo != nil {
+				networkRuntimeInfo = *hostRumtime.NetworkRuntimeInfo
+			}
 
 			netStackInstanceRuntimeInfo := networkRuntimeInfo.NetStackInstanceRuntimeInfo
 			for _, netStackInstanceRuntimeInfoItem := range netStackInstanceRuntimeInfo {
@@ -368,48 +501,29 @@ func (h *HostCollector) Collect(ch chan<- prometheus.Metric) {
 				netStackInstanceKey := netStackInstanceRuntimeInfoItem.NetStackInstanceKey
 				netStackInstanceState := netStackInstanceRuntimeInfoItem.State
 
-				metricLabelNames := append(hostLabelNames, "component")
 				metricLabelValues := append(hostLabelValues, netStackInstanceKey)
-				netStackStateDesc := prometheus.NewDesc(
-					prometheus.BuildFQName(namespace, hostSubsystem, "network_statck_state"),
-					"network stack state, 1 for active, 0 for inactive",
-					metricLabelNames,
-					nil,
-				)
 				var netStackstateValue float64
 				if netStackInstanceState == "active" {
 					netStackstateValue = float64(1)
 				} else {
 					netStackstateValue = float64(0)
 				}
-				ch <- prometheus.MustNewConstMetric(netStackStateDesc, prometheus.GaugeValue, netStackstateValue, metricLabelValues...)
+				h.stateMetrics["host_network_stack_state"].collect(ch, netStackInstanceState, netStackstateValue, metricLabelValues...)
 
 			}
 
-			networkResourceRuntime := networkRuntimeInfo.NetworkResourceRuntime.PnicResourceInfo
+			var networkResourceRuntime []types.HostPnicNetworkResourceInfo
+			if networkRuntimeInfo.NetworkResourceRuntime != nil {
+				networkResourceRuntime = networkRuntimeInfo.NetworkResourceRuntime.PnicResourceInfo
+			}
 			for _, pnicResourceInfoItem := range networkResourceRuntime {
 				pnicDevice := pnicResourceInfoItem.PnicDevice
 				pnicAvailableBandwidthForVMTraffic := pnicResourceInfoItem.AvailableBandwidthForVMTraffic
 				pnicUnusedBandwidthForVMTraffic := pnicResourceInfoItem.UnusedBandwidthForVMTraffic
 
-				metricLabelNames := append(hostLabelNames, "component")
 				metricLabelValues := append(hostLabelValues, pnicDevice)
-				pnicAvailableBandwidthForVMTrafficDesc := prometheus.NewDesc(
-					prometheus.BuildFQName(namespace, hostSubsystem, "pnic_available_bandwidth_for_vm_traffic"),
-					"pnic_available_bandwidth_for_vm_traffic",
-					metricLabelNames,
-					nil,
-				)
-
-				pnicUnusedBandwidthForVMTrafficDesc := prometheus.NewDesc(
-					prometheus.BuildFQName(namespace, hostSubsystem, "pnic_unused_bandwidth_for_vm_traffic"),
-					"pnic_unused_bandwidth_for_vm_traffic",
-					metricLabelNames,
-					nil,
-				)
-
-				ch <- prometheus.MustNewConstMetric(pnicAvailableBandwidthForVMTrafficDesc, prometheus.GaugeValue, float64(pnicAvailableBandwidthForVMTraffic), metricLabelValues...)
-				ch <- prometheus.MustNewConstMetric(pnicUnusedBandwidthForVMTrafficDesc, prometheus.GaugeValue, float64(pnicUnusedBandwidthForVMTraffic), metricLabelValues...)
+				ch <- prometheus.MustNewConstMetric(h.metrics["host_pnic_available_bandwidth_for_vm_traffic"].desc, prometheus.GaugeValue, float64(pnicAvailableBandwidthForVMTraffic), metricLabelValues...)
+				ch <- prometheus.MustNewConstMetric(h.metrics["host_pnic_unused_bandwidth_for_vm_traffic"].desc, prometheus.GaugeValue, float64(pnicUnusedBandwidthForVMTraffic), metricLabelValues...)
 
 			}
 			// retrieve the vmotion status
@@ -424,10 +538,11 @@ func (h *HostCollector) Collect(ch chan<- prometheus.Metric) {
 
 			// retrieve host fault tolerance status
 			var hostFaultToleranceStatusValue float64
-			if *hostSummary.Config.FaultToleranceEnabled {
+			if hostSummary.Config.FaultToleranceEnabled != nil && *hostSummary.Config.FaultToleranceEnabled {
 				hostFaultToleranceStatusValue = float64(1)
+			} else {
+				hostFaultToleranceStatusValue = float64(0)
 			}
-			hostFaultToleranceStatusValue = float64(0)
 			ch <- prometheus.MustNewConstMetric(h.metrics["host_fault_tolerance_status"].desc, prometheus.GaugeValue, hostFaultToleranceStatusValue, hostLabelValues...)
 
 			hostQuickStats := hostSummary.QuickStats
@@ -456,37 +571,70 @@ func (h *HostCollector) Collect(ch chan<- prometheus.Metric) {
 
 			// retrieve the overall status
 
-			hostOveralStatusValue := parseOveralStatus(hostSummary.OverallStatus)
+			h.stateMetrics["host_overall_status"].collect(ch, string(hostSummary.OverallStatus), parseOveralStatus(hostSummary.OverallStatus), hostLabelValues...)
 
-			ch <- prometheus.MustNewConstMetric(h.metrics["host_overall_status"].desc, prometheus.GaugeValue, hostOveralStatusValue, hostLabelValues...)
+			if hostHardware := hostSummary.Hardware; hostHardware != nil {
+				// retrieve the memory size
+				hostMemSizeValue := float64(hostHardware.MemorySize)
+				ch <- prometheus.MustNewConstMetric(h.metrics["host_memory_size"].desc, prometheus.GaugeValue, hostMemSizeValue, hostLabelValues...)
 
-			// retrieve the memory size
-			hostHardware := hostSummary.Hardware
-			hostMemSizeValue := float64(hostHardware.MemorySize)
-			ch <- prometheus.MustNewConstMetric(h.metrics["host_memory_size"].desc, prometheus.GaugeValue, hostMemSizeValue, hostLabelValues...)
+				// retrieve the cpu counts
+				hostCpuCountsValue := float64(hostHardware.NumCpuPkgs)
+				ch <- prometheus.MustNewConstMetric(h.metrics["host_cpu_sockets"].desc, prometheus.GaugeValue, hostCpuCountsValue, hostLabelValues...)
 
-			// retrieve the cpu counts
-			hostCpuCountsValue := float64(hostHardware.NumCpuPkgs)
-			ch <- prometheus.MustNewConstMetric(h.metrics["host_cpu_sockets"].desc, prometheus.GaugeValue, hostCpuCountsValue, hostLabelValues...)
+				// retrieve the cpu cores
+				hostCpuCoresValue := float64(hostHardware.NumCpuCores)
+				ch <- prometheus.MustNewConstMetric(h.metrics["host_cpu_cores"].desc, prometheus.GaugeValue, hostCpuCoresValue, hostLabelValues...)
 
-			// retrieve the cpu cores
-			hostCpuCoresValue := float64(hostHardware.NumCpuCores)
-			ch <- prometheus.MustNewConstMetric(h.metrics["host_cpu_cores"].desc, prometheus.GaugeValue, hostCpuCoresValue, hostLabelValues...)
+				// retrieve the cpu threads
+				hostCputhreadsValue := float64(hostHardware.NumCpuThreads)
+				ch <- prometheus.MustNewConstMetric(h.metrics["host_cpu_threads"].desc, prometheus.GaugeValue, hostCputhreadsValue, hostLabelValues...)
 
-			// retrieve the cpu threads
-			hostCputhreadsValue := float64(hostHardware.NumCpuThreads)
-			ch <- prometheus.MustNewConstMetric(h.metrics["host_cpu_threads"].desc, prometheus.GaugeValue, hostCputhreadsValue, hostLabelValues...)
+				// retrieve the nic counts
+				hostNicCountsValue := float64(hostHardware.NumNics)
+				ch <- prometheus.MustNewConstMetric(h.metrics["host_nic_counts"].desc, prometheus.GaugeValue, hostNicCountsValue, hostLabelValues...)
 
-			// retrieve the nic counts
-			hostNicCountsValue := float64(hostHardware.NumNics)
-			ch <- prometheus.MustNewConstMetric(h.metrics["host_nic_counts"].desc, prometheus.GaugeValue, hostNicCountsValue, hostLabelValues...)
-
-			// retrieve the hba counts
-			hostHbaCountsValue := float64(hostHardware.NumHBAs)
-			ch <- prometheus.MustNewConstMetric(h.metrics["host_hba_counts"].desc, prometheus.GaugeValue, hostHbaCountsValue, hostLabelValues...)
+				// retrieve the hba counts
+				hostHbaCountsValue := float64(hostHardware.NumHBAs)
+				ch <- prometheus.MustNewConstMetric(h.metrics["host_hba_counts"].desc, prometheus.GaugeValue, hostHbaCountsValue, hostLabelValues...)
+			}
 
 		}
 
 		h.collectorScrapeStatus.WithLabelValues("host").Set(float64(1))
 	}
 }
+
+// collectHardwareStatus sends the status of each hardware element as a state set
+func (h *HostCollector) collectHardwareStatus(ch chan<- prometheus.Metric, metricName string, elements []types.BaseHostHardwareElementInfo, hostLabelValues []string) {
+	for _, element := range elements {
+		elementInfo := element.GetHostHardwareElementInfo()
+		metricLabelValues := append(hostLabelValues, elementInfo.Name)
+		for _, metric := range newStateSetMetrics(h.metrics[metricName].desc, hostHealthStates, parseHealthState(elementInfo.Status), metricLabelValues...) {
+			ch <- metric
+		}
+	}
+}
+
+// fillHealthSystemRuntime sources the health of the hosts without healthSystemRuntime from their HostHealthStatusSystem
+func (h *HostCollector) fillHealthSystemRuntime(hostList []mo.HostSystem) {
+	var hosts []mo.HostSystem
+	for _, host := range hostList {
+		if host.Runtime.HealthSystemRuntime == nil {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		return
+	}
+	healthSystemRuntimes, err := h.source.ListHealthSystemRuntime(hosts)
+	if err != nil {
+		h.logger.Error("Errors Getting host health status system from vsphere", "err", err)
+		return
+	}
+	for i := range hostList {
+		if healthSystemRuntime, ok := healthSystemRuntimes[hostList[i].Self]; ok && hostList[i].Runtime.HealthSystemRuntime == nil {
+			hostList[i].Runtime.HealthSystemRuntime = &healthSystemRuntime
+		}
+	}
+}
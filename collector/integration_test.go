@@ -0,0 +1,134 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/vmware/govmomi/simulator"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var update = flag.Bool("update", false, "update the expected metrics in testdata")
+
+// stableGatherer drops the metrics which vary between scrapes of the same inventory
+type stableGatherer struct {
+	prometheus.Gatherer
+}
+
+func (g stableGatherer) Gather() ([]*dto.MetricFamily, error) {
+	metricFamilies, err := g.Gatherer.Gather()
+	var stable []*dto.MetricFamily
+	for _, metricFamily := range metricFamilies {
+		if metricFamily.GetName() != "vsphere_exporter_collector_duration_seconds" {
+			stable = append(stable, metricFamily)
+		}
+	}
+	return stable, err
+}
+
+// compareGolden compares the gathered metrics with testdata/<name>.prom, which is rewritten with -update
+func compareGolden(t *testing.T, gatherer prometheus.Gatherer, name string) {
+	t.Helper()
+	golden := filepath.Join("testdata", name+".prom")
+	gatherer = stableGatherer{gatherer}
+	if *update {
+		metricFamilies, err := gatherer.Gather()
+		if err != nil {
+			t.Fatalf("Error when gathering metrics, %v", err)
+		}
+		var buf bytes.Buffer
+		for _, metricFamily := range metricFamilies {
+			if _, err := expfmt.MetricFamilyToText(&buf, metricFamily); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := os.Open(golden)
+	if err != nil {
+		t.Fatalf("Error when reading expected metrics, %v", err)
+	}
+	defer expected.Close()
+	if err := testutil.GatherAndCompare(gatherer, expected); err != nil {
+		t.Error(err)
+	}
+}
+
+// newIntegrationModel returns a vCenter with a standalone and a clustered host, each running one vm,
+// a single host per cluster keeps the vm placement deterministic
+func newIntegrationModel() *simulator.Model {
+	model := simulator.VPX()
+	model.Host = 1
+	model.ClusterHost = 1
+	model.Machine = 1
+	return model
+}
+
+// setVMUptime sets the uptime of every vm, the simulator reports 0 otherwise
+func setVMUptime(registry *simulator.Registry) {
+	for _, entity := range registry.All("VirtualMachine") {
+		vm := entity.(*simulator.VirtualMachine)
+		vm.Summary.QuickStats.UptimeSeconds = 3600
+	}
+}
+
+func TestIntegrationVCenter(t *testing.T) {
+	target := startTestModel(t, newIntegrationModel(), setVMUptime)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newTestCollector(target))
+	compareGolden(t, registry, "vcenter")
+}
+
+func TestIntegrationESXi(t *testing.T) {
+	target := startTestModel(t, simulator.ESX(), setVMUptime)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newTestCollector(target))
+	compareGolden(t, registry, "esxi")
+}
+
+func TestIntegrationUnreachable(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newTestCollector("127.0.0.1:1"))
+	compareGolden(t, registry, "unreachable")
+}
+
+func TestIntegrationRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	setFlags := func(args ...string) {
+		t.Helper()
+		if _, err := kingpin.CommandLine.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer setFlags("--record.dir=", "--replay.dir=")
+
+	target := startTestModel(t, simulator.ESX(), setVMUptime)
+	setFlags("--record.dir=" + dir)
+	if _, err := scrape(target); err != nil {
+		t.Fatalf("Error when recording %s, %v", target, err)
+	}
+
+	// the replayed scrape doesn't need the target anymore
+	setFlags("--record.dir=", "--replay.dir="+dir)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewVshpereCollector(context.Background(), target, &config.ClusterConfig{}))
+	compareGolden(t, registry, "esxi")
+}
@@ -0,0 +1,150 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// ErrScrapeQueueFull is returned when a scrape can neither join an in-flight collection nor be queued
+var ErrScrapeQueueFull = errors.New("too many scrapes waiting for a collection slot")
+
+// Metrics of the scrape limiter, they are exposed on /metrics
+var (
+	scrapesInFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "scrapes_in_flight",
+			Help:      "Number of collections currently running against the targets.",
+		},
+	)
+	scrapesQueued = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "scrapes_queued",
+			Help:      "Number of collections waiting for a free slot.",
+		},
+	)
+	scrapesCoalesced = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "scrapes_coalesced_total",
+			Help:      "Number of scrapes served by a collection already in flight for the same target.",
+		},
+		[]string{"target"},
+	)
+	scrapesRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "scrapes_rejected_total",
+			Help:      "Number of scrapes rejected because the queue was full.",
+		},
+		[]string{"target"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(scrapesInFlight, scrapesQueued, scrapesCoalesced, scrapesRejected)
+}
+
+// ScrapeLimiter coalesces concurrent scrapes of the same target into one collection and bounds the collections running at once,
+// so e.g. a HA pair of Prometheus servers opens a single session per target.
+type ScrapeLimiter struct {
+	mu       sync.Mutex
+	inFlight map[string]*scrapeCall
+	slots    chan struct{}
+	queued   int
+	maxQueue int
+}
+
+// scrapeCall is a collection shared by all the scrapes of the same key arriving while it is in flight
+type scrapeCall struct {
+	done           chan struct{}
+	metricFamilies []*dto.MetricFamily
+	err            error
+}
+
+// NewScrapeLimiter returns a limiter running at most maxConcurrency collections at once, 0 means unlimited,
+// and queuing at most maxQueue collections beyond that
+func NewScrapeLimiter(maxConcurrency int, maxQueue int) *ScrapeLimiter {
+	limiter := &ScrapeLimiter{
+		inFlight: map[string]*scrapeCall{},
+		maxQueue: maxQueue,
+	}
+	if maxConcurrency > 0 {
+		limiter.slots = make(chan struct{}, maxConcurrency)
+	}
+	return limiter
+}
+
+// Gather runs collect for the target, unless a collection of the same key is in flight, then its result is shared.
+// The key identifies what is collected, e.g. the target along with the config it resolved to, so the scrapes only share identical collections.
+// The returned metric families must not be modified as they may be shared between scrapes.
+func (l *ScrapeLimiter) Gather(target string, key string, collect func() ([]*dto.MetricFamily, error)) ([]*dto.MetricFamily, error) {
+	l.mu.Lock()
+	if call, ok := l.inFlight[key]; ok {
+		l.mu.Unlock()
+		scrapesCoalesced.WithLabelValues(target).Inc()
+		<-call.done
+		return call.metricFamilies, call.err
+	}
+
+	queue := false
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			if l.queued >= l.maxQueue {
+				l.mu.Unlock()
+				scrapesRejected.WithLabelValues(target).Inc()
+				return nil, ErrScrapeQueueFull
+			}
+			l.queued++
+			queue = true
+		}
+	}
+	// the call is registered before waiting for a slot, so scrapes of a queued target join it as well
+	call := &scrapeCall{done: make(chan struct{})}
+	l.inFlight[key] = call
+	l.mu.Unlock()
+
+	if queue {
+		scrapesQueued.Inc()
+		l.slots <- struct{}{}
+		scrapesQueued.Dec()
+		l.mu.Lock()
+		l.queued--
+		l.mu.Unlock()
+	}
+
+	scrapesInFlight.Inc()
+	defer func() {
+		scrapesInFlight.Dec()
+		if l.slots != nil {
+			<-l.slots
+		}
+		l.mu.Lock()
+		delete(l.inFlight, key)
+		l.mu.Unlock()
+		close(call.done)
+	}()
+	call.metricFamilies, call.err = runCollect(collect)
+	return call.metricFamilies, call.err
+}
+
+// runCollect runs collect and returns a panic as its error, so the slot is released and the scrapes sharing the collection fail
+func runCollect(collect func() ([]*dto.MetricFamily, error)) (metricFamilies []*dto.MetricFamily, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("collection panicked, %v", r)
+		}
+	}()
+	return collect()
+}
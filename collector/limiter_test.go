@@ -0,0 +1,144 @@
+package collector
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// waitInFlight waits until the limiter tracks the given number of collections
+func waitInFlight(t *testing.T, limiter *ScrapeLimiter, count int) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		limiter.mu.Lock()
+		inFlight := len(limiter.inFlight)
+		limiter.mu.Unlock()
+		if inFlight == count {
+			return
+		}
+	}
+	t.Fatalf("expected %d collections in flight", count)
+}
+
+func TestScrapeLimiterCoalescing(t *testing.T) {
+	limiter := NewScrapeLimiter(2, 0)
+	release := make(chan struct{})
+	var collections int32
+	collect := func() ([]*dto.MetricFamily, error) {
+		atomic.AddInt32(&collections, 1)
+		<-release
+		return []*dto.MetricFamily{{}}, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan []*dto.MetricFamily, 6)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		metricFamilies, _ := limiter.Gather("vc01", "clusters[vc01]", collect)
+		results <- metricFamilies
+	}()
+	waitInFlight(t, limiter, 1)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			metricFamilies, _ := limiter.Gather("vc01", "clusters[vc01]", collect)
+			results <- metricFamilies
+		}()
+	}
+	// a scrape of the target resolving to another config doesn't share the collection
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		metricFamilies, _ := limiter.Gather("vc01", "clusters[default]", collect)
+		results <- metricFamilies
+	}()
+	waitInFlight(t, limiter, 2)
+	// give the scrapes time to join the collection before releasing it
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if collections != 2 {
+		t.Errorf("expected a collection per key, got %d", collections)
+	}
+	for metricFamilies := range results {
+		if len(metricFamilies) != 1 {
+			t.Errorf("expected the shared result, got %v", metricFamilies)
+		}
+	}
+}
+
+func TestScrapeLimiterQueue(t *testing.T) {
+	limiter := NewScrapeLimiter(1, 1)
+	release := make(chan struct{})
+	collect := func() ([]*dto.MetricFamily, error) {
+		<-release
+		return nil, nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i, target := range []string{"vc01", "vc02"} {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			_, err := limiter.Gather(target, target, collect)
+			errs <- err
+		}(target)
+		waitInFlight(t, limiter, i+1)
+	}
+
+	// vc01 is running and vc02 is queued, vc03 doesn't fit
+	if _, err := limiter.Gather("vc03", "vc03", collect); err != ErrScrapeQueueFull {
+		t.Errorf("expected the scrape to be rejected, got %v", err)
+	}
+
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("expected the scrape to succeed, got %v", err)
+		}
+	}
+}
+
+func TestScrapeLimiterPanic(t *testing.T) {
+	limiter := NewScrapeLimiter(1, 0)
+	release := make(chan struct{})
+	panicking := func() ([]*dto.MetricFamily, error) {
+		<-release
+		panic("collector failed")
+	}
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := limiter.Gather("vc01", "vc01", panicking)
+			errs <- err
+		}()
+		if i == 0 {
+			waitInFlight(t, limiter, 1)
+		}
+	}
+	// give the second scrape time to join the collection before releasing it
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil {
+			t.Errorf("expected the panic as the error of the scrapes sharing the collection")
+		}
+	}
+
+	// the slot and the key are released
+	metricFamilies, err := limiter.Gather("vc01", "vc01", func() ([]*dto.MetricFamily, error) { return []*dto.MetricFamily{{}}, nil })
+	if err != nil || len(metricFamilies) != 1 {
+		t.Errorf("expected a new collection after the panic, got %v, %v", metricFamilies, err)
+	}
+}
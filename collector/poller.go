@@ -0,0 +1,253 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/jenningsloy318/vsphere_exporter/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	lastCollectionTimestampDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "last_collection_timestamp_seconds"),
+		"time of the last complete collection of the target, the metrics served are from that collection",
+		nil, nil,
+	)
+	lastCollectionDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "last_collection_duration_seconds"),
+		"duration of the last complete collection of the target",
+		nil, nil,
+	)
+	lastCollectionSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "last_collection_success"),
+		"whether the latest collection of the target succeeded, 0 if the metrics served are from an older collection",
+		nil, nil,
+	)
+	collectionAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "collection_age_seconds"),
+		"seconds since the last complete collection of the target",
+		nil, nil,
+	)
+	collectionStaleDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "collection_stale"),
+		"whether the last complete collection of the target is older than twice its poll interval",
+		nil, nil,
+	)
+)
+
+var errTargetDown = errors.New("target is down")
+
+// Poller collects the configured targets in the background, each at its own poll interval, and serves their last complete collection,
+// so a scrape doesn't wait for the target
+type Poller struct {
+	ctx             context.Context
+	defaultInterval time.Duration
+	mu              sync.Mutex
+	targets         map[string]*polledTarget
+}
+
+type polledTarget struct {
+	clusterConfig config.ClusterConfig
+	interval      time.Duration
+	cancel        context.CancelFunc
+
+	mu sync.Mutex
+	// metricFamilies are the result of the last complete collection
+	metricFamilies []*dto.MetricFamily
+	collectedAt    time.Time
+	duration       time.Duration
+	success        bool
+}
+
+// NewPoller returns a poller collecting the targets at defaultInterval unless their poll_interval is set, it runs until ctx is done
+func NewPoller(ctx context.Context, defaultInterval time.Duration) *Poller {
+	return &Poller{
+		ctx:             ctx,
+		defaultInterval: defaultInterval,
+		targets:         map[string]*polledTarget{},
+	}
+}
+
+// SetTargets starts polling the new targets and the targets whose config changed, and stops polling the removed ones.
+// The last collection of a target whose config changed is served until it is collected again.
+func (p *Poller) SetTargets(clusterConfigs map[string]*config.ClusterConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for target, polled := range p.targets {
+		if clusterConfig, ok := clusterConfigs[target]; !ok || !reflect.DeepEqual(*clusterConfig, polled.clusterConfig) {
+			polled.cancel()
+			if !ok {
+				delete(p.targets, target)
+			}
+		}
+	}
+	for target, clusterConfig := range clusterConfigs {
+		polled, ok := p.targets[target]
+		if ok && reflect.DeepEqual(*clusterConfig, polled.clusterConfig) {
+			continue
+		}
+		interval := clusterConfig.PollInterval
+		if interval <= 0 {
+			interval = p.defaultInterval
+		}
+		next := &polledTarget{clusterConfig: *clusterConfig, interval: interval}
+		if ok {
+			next.metricFamilies, next.collectedAt, next.duration, next.success = polled.snapshot()
+		}
+		ctx, cancel := context.WithCancel(p.ctx)
+		next.cancel = cancel
+		p.targets[target] = next
+		go p.poll(ctx, target, next)
+	}
+}
+
+// poll collects the target right away, then at every interval until ctx is done
+func (p *Poller) poll(ctx context.Context, target string, polled *polledTarget) {
+	ticker := time.NewTicker(polled.interval)
+	defer ticker.Stop()
+	for {
+		p.collect(ctx, target, polled)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Poller) collect(ctx context.Context, target string, polled *polledTarget) {
+	start := time.Now()
+	logger := logging.FromContext(ctx).With(logging.ModuleKey, "clusters["+target+"]")
+	collector := NewVshpereCollector(logging.NewContext(ctx, logger), target, &polled.clusterConfig)
+	collector.logger.Info("starting polling target")
+	registry := prometheus.NewRegistry()
+	var metricFamilies []*dto.MetricFamily
+	err := registry.Register(collector)
+	if err == nil {
+		metricFamilies, err = registry.Gather()
+	}
+	if err == nil && collector.source == nil {
+		err = errTargetDown
+	}
+
+	polled.mu.Lock()
+	defer polled.mu.Unlock()
+	if err != nil {
+		collector.logger.Error("error when polling target, the last complete collection is kept", "err", err)
+		polled.success = false
+		return
+	}
+	polled.metricFamilies = metricFamilies
+	polled.collectedAt = start
+	polled.duration = time.Since(start)
+	polled.success = true
+}
+
+func (t *polledTarget) snapshot() ([]*dto.MetricFamily, time.Time, time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.metricFamilies, t.collectedAt, t.duration, t.success
+}
+
+// Gatherer returns the last complete collection of the target along with its staleness, false if the target isn't polled
+func (p *Poller) Gatherer(target string) (prometheus.Gatherer, bool) {
+	p.mu.Lock()
+	polled, ok := p.targets[target]
+	p.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return polled.gather()
+	}), true
+}
+
+// GatherAll returns the last complete collection of every polled target, each series is labelled with its vcenter
+func (p *Poller) GatherAll() ([]*dto.MetricFamily, error) {
+	p.mu.Lock()
+	targets := make([]string, 0, len(p.targets))
+	for target := range p.targets {
+		targets = append(targets, target)
+	}
+	polledTargets := p.targets
+	p.mu.Unlock()
+	sort.Strings(targets)
+
+	gatherers := prometheus.Gatherers{}
+	for _, target := range targets {
+		polled := polledTargets[target]
+		registry := prometheus.NewRegistry()
+		prometheus.WrapRegistererWith(prometheus.Labels{"vcenter": target}, registry).MustRegister(polledCollector{polled})
+		gatherers = append(gatherers, registry)
+	}
+	return gatherers.Gather()
+}
+
+func (t *polledTarget) gather() ([]*dto.MetricFamily, error) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(polledCollector{t})
+	return registry.Gather()
+}
+
+// polledCollector sends the last complete collection of a target and its staleness
+type polledCollector struct {
+	polled *polledTarget
+}
+
+// Describe sends no desc, the metrics of the collection are only known once collected
+func (c polledCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c polledCollector) Collect(ch chan<- prometheus.Metric) {
+	metricFamilies, collectedAt, duration, success := c.polled.snapshot()
+	for _, metricFamily := range metricFamilies {
+		for _, metric := range metricFamily.GetMetric() {
+			ch <- familyMetric{family: metricFamily, metric: metric}
+		}
+	}
+
+	var successValue float64
+	if success {
+		successValue = 1
+	}
+	ch <- prometheus.MustNewConstMetric(lastCollectionSuccessDesc, prometheus.GaugeValue, successValue)
+	if collectedAt.IsZero() {
+		return
+	}
+	age := time.Since(collectedAt)
+	var stale float64
+	if age > 2*c.polled.interval {
+		stale = 1
+	}
+	ch <- prometheus.MustNewConstMetric(lastCollectionTimestampDesc, prometheus.GaugeValue, float64(collectedAt.UnixNano())/1e9)
+	ch <- prometheus.MustNewConstMetric(lastCollectionDurationDesc, prometheus.GaugeValue, duration.Seconds())
+	ch <- prometheus.MustNewConstMetric(collectionAgeDesc, prometheus.GaugeValue, age.Seconds())
+	ch <- prometheus.MustNewConstMetric(collectionStaleDesc, prometheus.GaugeValue, stale)
+}
+
+// familyMetric is a collected series sent again as a prometheus.Metric
+type familyMetric struct {
+	family *dto.MetricFamily
+	metric *dto.Metric
+}
+
+func (m familyMetric) Desc() *prometheus.Desc {
+	labelNames := make([]string, 0, len(m.metric.GetLabel()))
+	for _, label := range m.metric.GetLabel() {
+		labelNames = append(labelNames, label.GetName())
+	}
+	return prometheus.NewDesc(m.family.GetName(), m.family.GetHelp(), labelNames, nil)
+}
+
+func (m familyMetric) Write(out *dto.Metric) error {
+	*out = *m.metric
+	// the labels of the collection are shared by every scrape, they are copied before the vcenter label is added
+	out.Label = append([]*dto.LabelPair(nil), m.metric.Label...)
+	return nil
+}
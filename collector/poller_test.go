@@ -0,0 +1,81 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// waitForCollection gathers the target until last_collection_success is 1, or fails after a while
+func waitForCollection(t *testing.T, poller *Poller, target string) []*dto.MetricFamily {
+	t.Helper()
+	gatherer, ok := poller.Gatherer(target)
+	if !ok {
+		t.Fatalf("expected target %s to be polled", target)
+	}
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		metricFamilies, err := gatherer.Gather()
+		if err != nil {
+			t.Fatalf("Error when gathering target %s, %v", target, err)
+		}
+		if success := findMetricFamily(metricFamilies, "vsphere_last_collection_success"); success.GetMetric()[0].GetGauge().GetValue() == 1 {
+			return metricFamilies
+		}
+	}
+	t.Fatalf("expected target %s to be collected", target)
+	return nil
+}
+
+func TestPoller(t *testing.T) {
+	target := newTestVCenter(t, 1)
+	unreachable := "127.0.0.1:1"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	poller := NewPoller(ctx, time.Hour)
+	poller.SetTargets(map[string]*config.ClusterConfig{
+		target:      {Username: "user", Password: "pass", ConnectTimeout: time.Second},
+		unreachable: {Username: "user", Password: "pass", ConnectTimeout: time.Second},
+	})
+
+	metricFamilies := waitForCollection(t, poller, target)
+	for _, name := range []string{"vsphere_up", "vsphere_host_uptime", "vsphere_last_collection_timestamp_seconds", "vsphere_collection_age_seconds"} {
+		if findMetricFamily(metricFamilies, name) == nil {
+			t.Errorf("expected %s in the collection of %s", name, target)
+		}
+	}
+	if stale := findMetricFamily(metricFamilies, "vsphere_collection_stale"); stale.GetMetric()[0].GetGauge().GetValue() != 0 {
+		t.Errorf("expected a fresh collection, got %v", stale)
+	}
+
+	// a target never collected only reports the failure
+	gatherer, _ := poller.Gatherer(unreachable)
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if metricFamilies, _ = gatherer.Gather(); len(metricFamilies) > 0 {
+			break
+		}
+	}
+	if len(metricFamilies) != 1 || metricFamilies[0].GetName() != "vsphere_last_collection_success" || metricFamilies[0].GetMetric()[0].GetGauge().GetValue() != 0 {
+		t.Errorf("expected only a failed collection of %s, got %v", unreachable, metricFamilies)
+	}
+
+	metricFamilies, err := poller.GatherAll()
+	if err != nil {
+		t.Fatalf("Error when gathering all targets, %v", err)
+	}
+	vcenters := map[string]bool{}
+	for _, metric := range findMetricFamily(metricFamilies, "vsphere_last_collection_success").GetMetric() {
+		vcenters[labelValue(metric, "vcenter")] = true
+	}
+	if !vcenters[target] || !vcenters[unreachable] {
+		t.Errorf("expected the collections of both targets labelled with their vcenter, got %v", vcenters)
+	}
+
+	poller.SetTargets(map[string]*config.ClusterConfig{target: {Username: "user", Password: "pass", ConnectTimeout: time.Second}})
+	if _, ok := poller.Gatherer(unreachable); ok {
+		t.Errorf("expected %s to be no longer polled", unreachable)
+	}
+}
@@ -0,0 +1,56 @@
+package collector
+
+import (
+	"sort"
+
+	"github.com/jenningsloy318/vsphere_exporter/vmware"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var privilegeGrantedDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "privilege_granted"),
+	"whether the account has a privilege a collector needs on the root folder, without it the collector may return no metrics",
+	[]string{"collector", "privilege"}, nil,
+)
+
+// PrivilegeCheck tells whether the account has a privilege a collector needs
+type PrivilegeCheck struct {
+	Collector string `json:"collector"`
+	Privilege string `json:"privilege"`
+	Granted   bool   `json:"granted"`
+}
+
+// CheckPrivileges checks the privileges the named collectors need with a single call, the checks are ordered by collector
+func CheckPrivileges(source vmware.Source, collectors []string) ([]PrivilegeCheck, error) {
+	collectors = append([]string(nil), collectors...)
+	sort.Strings(collectors)
+	registrationsMu.Lock()
+	var privileges []string
+	collectorPrivileges := map[string][]string{}
+	for _, name := range collectors {
+		if registration, ok := registrations[name]; ok {
+			collectorPrivileges[name] = registration.currentPrivileges()
+			for _, privilege := range collectorPrivileges[name] {
+				if !containsString(privileges, privilege) {
+					privileges = append(privileges, privilege)
+				}
+			}
+		}
+	}
+	registrationsMu.Unlock()
+	if len(privileges) == 0 {
+		return nil, nil
+	}
+
+	granted, err := source.PrivilegesGranted(privileges)
+	if err != nil {
+		return nil, err
+	}
+	var checks []PrivilegeCheck
+	for _, name := range collectors {
+		for _, privilege := range collectorPrivileges[name] {
+			checks = append(checks, PrivilegeCheck{Collector: name, Privilege: privilege, Granted: granted[privilege]})
+		}
+	}
+	return checks, nil
+}
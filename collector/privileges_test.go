@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/jenningsloy318/vsphere_exporter/vmware"
+)
+
+// privilegeSource grants the privileges set to true, the methods not overridden panic
+type privilegeSource struct {
+	vmware.Source
+	granted map[string]bool
+	calls   int
+}
+
+func (s *privilegeSource) PrivilegesGranted(privileges []string) (map[string]bool, error) {
+	s.calls++
+	granted := map[string]bool{}
+	for _, privilege := range privileges {
+		granted[privilege] = s.granted[privilege]
+	}
+	return granted, nil
+}
+
+func TestCheckPrivileges(t *testing.T) {
+	source := &privilegeSource{granted: map[string]bool{"System.View": true, "System.Read": true}}
+
+	checks, err := CheckPrivileges(source, []string{"vm", "host", "unknown"})
+	if err != nil {
+		t.Fatalf("Error when checking the privileges, %v", err)
+	}
+	if source.calls != 1 {
+		t.Errorf("Expected the privileges of all collectors to be checked at once, got %d calls", source.calls)
+	}
+	var missing []PrivilegeCheck
+	for _, check := range checks {
+		if !check.Granted {
+			missing = append(missing, check)
+		}
+	}
+	if len(checks) != 5 || checks[0].Collector != "host" || checks[4].Collector != "vm" {
+		t.Errorf("Expected the privileges of the host then vm collectors, got %+v", checks)
+	}
+	if len(missing) != 1 || missing[0] != (PrivilegeCheck{Collector: "host", Privilege: "Host.Config.Settings"}) {
+		t.Errorf("Expected Host.Config.Settings of the host collector to be missing, got %+v", missing)
+	}
+
+	if checks, err := CheckPrivileges(source, nil); err != nil || len(checks) != 0 {
+		t.Errorf("Expected no check without collectors, got %+v, %v", checks, err)
+	}
+}
+
+func TestCustomCollectorPrivileges(t *testing.T) {
+	source := &privilegeSource{granted: map[string]bool{}}
+
+	if checks, err := CheckPrivileges(source, []string{"custom"}); err != nil || len(checks) != 0 || source.calls != 0 {
+		t.Errorf("Expected no privileges of the custom collector without custom metrics, got %+v, %v", checks, err)
+	}
+
+	SetCustomMetrics([]config.CustomMetric{{Name: "host_cpu_mhz", Object: "HostSystem", Property: "summary.hardware.cpuMhz"}})
+	defer SetCustomMetrics(nil)
+	checks, err := CheckPrivileges(source, []string{"custom"})
+	if err != nil || len(checks) != 2 || checks[0].Collector != "custom" || checks[0].Granted {
+		t.Errorf("Expected the missing privileges of the custom collector, got %+v, %v", checks, err)
+	}
+}
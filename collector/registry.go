@@ -0,0 +1,184 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/jenningsloy318/vsphere_exporter/logging"
+	"github.com/jenningsloy318/vsphere_exporter/vmware"
+	"github.com/prometheus/client_golang/prometheus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Factory builds a collector for one scrape of a target, applying the filters the target resolved to and logging with the logger of the scrape
+type Factory func(namespace string, source vmware.Source, inventory *vmware.Inventory, filters map[string]*config.FilterConfig, logger *slog.Logger) prometheus.Collector
+
+// registration is a collector known to the exporter
+type registration struct {
+	name           string
+	defaultEnabled bool
+	// properties are the properties the collector retrieves, by managed object type
+	properties map[string][]string
+	// propertiesFunc gives the properties of a collector whose properties are configured, instead of properties
+	propertiesFunc func() map[string][]string
+	// privileges are the privileges the collector needs on the root folder, without them it may silently return no metrics
+	privileges []string
+	// privilegesFunc gives the privileges of a collector which only needs them when configured, instead of privileges
+	privilegesFunc func() []string
+	factory        Factory
+	enabled        *bool
+}
+
+// currentPrivileges returns the privileges the collector needs with the current config
+func (r *registration) currentPrivileges() []string {
+	if r.privilegesFunc != nil {
+		return r.privilegesFunc()
+	}
+	return r.privileges
+}
+
+var (
+	registrationsMu sync.Mutex
+	registrations   = map[string]*registration{}
+)
+
+// registerCollector makes a collector available, it is enabled or disabled with --[no-]collector.<name>.
+// It is called from the init function of the file implementing the collector.
+func registerCollector(name string, defaultEnabled bool, properties map[string][]string, privileges []string, factory Factory) {
+	registrationsMu.Lock()
+	defer registrationsMu.Unlock()
+	if _, ok := registrations[name]; ok {
+		panic(fmt.Sprintf("collector %s is registered twice", name))
+	}
+
+	defaultValue := "disabled"
+	if defaultEnabled {
+		defaultValue = "enabled"
+	}
+	enabled := kingpin.Flag(
+		"collector."+name,
+		fmt.Sprintf("Enable the %s collector (default: %s).", name, defaultValue),
+	).Default(fmt.Sprintf("%v", defaultEnabled)).Bool()
+	// the default only applies once the flags are parsed, which tests don't do
+	*enabled = defaultEnabled
+
+	registrations[name] = &registration{
+		name:           name,
+		defaultEnabled: defaultEnabled,
+		properties:     properties,
+		privileges:     privileges,
+		factory:        factory,
+		enabled:        enabled,
+	}
+}
+
+// sortedRegistrations returns the registered collectors ordered by name, so they are always collected in the same order
+func sortedRegistrations() []*registration {
+	registrationsMu.Lock()
+	defer registrationsMu.Unlock()
+	sorted := make([]*registration, 0, len(registrations))
+	for _, registration := range registrations {
+		sorted = append(sorted, registration)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].name < sorted[j].name })
+	return sorted
+}
+
+// newEnabledCollectors builds the enabled collectors for a scrape, keyed by name, their logs carry their name
+func newEnabledCollectors(source vmware.Source, inventory *vmware.Inventory, filters map[string]*config.FilterConfig, logger *slog.Logger) map[string]prometheus.Collector {
+	collectors := map[string]prometheus.Collector{}
+	for _, registration := range sortedRegistrations() {
+		if *registration.enabled {
+			collectors[registration.name] = registration.factory(namespace, source, inventory, filters, logger.With(logging.CollectorKey, registration.name))
+		}
+	}
+	return collectors
+}
+
+// EnabledCollectors returns the names of the enabled collectors
+func EnabledCollectors() []string {
+	var names []string
+	for _, registration := range sortedRegistrations() {
+		if *registration.enabled {
+			names = append(names, registration.name)
+		}
+	}
+	return names
+}
+
+// BuiltinMetricNames returns the names of the metrics of a scrape other than the custom metrics, from the descriptors of
+// the registered collectors, so the custom metrics of the config can be checked against them
+func BuiltinMetricNames() []string {
+	descs := []*prometheus.Desc{
+		targetInfoDesc, privilegeGrantedDesc, totalScrapeDurationDesc, filteredObjectsDesc,
+		lastCollectionTimestampDesc, lastCollectionDurationDesc, lastCollectionSuccessDesc, collectionAgeDesc, collectionStaleDesc,
+	}
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		defer close(ch)
+		for _, registration := range sortedRegistrations() {
+			if registration.name == "custom" {
+				continue
+			}
+			// the collectors only retrieve from their source when collected
+			registration.factory(namespace, nil, nil, nil, logging.Logger()).Describe(ch)
+		}
+	}()
+	for desc := range ch {
+		descs = append(descs, desc)
+	}
+
+	names := []string{prometheus.BuildFQName(namespace, "", "up")}
+	for _, desc := range descs {
+		if match := descFQName.FindStringSubmatch(desc.String()); match != nil {
+			names = append(names, match[1])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CollectorInfo describes a registered collector
+type CollectorInfo struct {
+	Name           string              `json:"name"`
+	Enabled        bool                `json:"enabled"`
+	DefaultEnabled bool                `json:"default_enabled"`
+	Properties     map[string][]string `json:"properties"`
+	Privileges     []string            `json:"privileges"`
+}
+
+// Collectors returns the registered collectors ordered by name
+func Collectors() []CollectorInfo {
+	var infos []CollectorInfo
+	for _, registration := range sortedRegistrations() {
+		properties := registration.properties
+		if registration.propertiesFunc != nil {
+			properties = registration.propertiesFunc()
+		}
+		infos = append(infos, CollectorInfo{
+			Name:           registration.name,
+			Enabled:        *registration.enabled,
+			DefaultEnabled: registration.defaultEnabled,
+			Properties:     properties,
+			Privileges:     registration.currentPrivileges(),
+		})
+	}
+	return infos
+}
+
+// CollectorsHandler lists the registered collectors as JSON
+func CollectorsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(Collectors()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
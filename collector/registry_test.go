@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestCollectorsHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	CollectorsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/collectors", nil))
+
+	var infos []CollectorInfo
+	if err := json.Unmarshal(recorder.Body.Bytes(), &infos); err != nil {
+		t.Fatalf("Error when decoding %s, %v", recorder.Body.String(), err)
+	}
+	if len(infos) != 3 || infos[0].Name != "custom" || infos[1].Name != "host" || infos[2].Name != "vm" {
+		t.Fatalf("expected the custom, host and vm collectors, got %+v", infos)
+	}
+	for _, info := range infos[1:] {
+		if !info.Enabled || !info.DefaultEnabled || len(info.Properties) == 0 || len(info.Privileges) == 0 {
+			t.Errorf("expected collector %s to be enabled with its properties and privileges, got %+v", info.Name, info)
+		}
+	}
+}
+
+func TestDisabledCollector(t *testing.T) {
+	target := newTestVCenter(t, 1)
+	*registrations["vm"].enabled = false
+	defer func() { *registrations["vm"].enabled = true }()
+
+	metricFamilies, err := scrape(target)
+	if err != nil {
+		t.Fatalf("Error when scraping %s, %v", target, err)
+	}
+	if findMetricFamily(metricFamilies, "vsphere_host_uptime") == nil {
+		t.Errorf("expected the host metrics")
+	}
+	if vmUptime := findMetricFamily(metricFamilies, "vsphere_vm_uptime"); vmUptime != nil {
+		t.Errorf("expected no vm metrics, got %v", vmUptime)
+	}
+}
+
+func TestBuiltinMetricNames(t *testing.T) {
+	names := map[string]bool{}
+	for _, name := range BuiltinMetricNames() {
+		names[name] = true
+	}
+	goldenFiles, err := filepath.Glob("testdata/*.prom")
+	if err != nil || len(goldenFiles) == 0 {
+		t.Fatalf("expected the golden files, %v", err)
+	}
+	for _, goldenFile := range goldenFiles {
+		golden, err := os.Open(goldenFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parser expfmt.TextParser
+		metricFamilies, err := parser.TextToMetricFamilies(golden)
+		golden.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name := range metricFamilies {
+			if !names[name] {
+				t.Errorf("expected %s of %s to be a built-in metric", name, goldenFile)
+			}
+		}
+	}
+	for _, name := range []string{"vsphere_up", "vsphere_host_pnic_unused_bandwidth_for_vm_traffic", "vsphere_last_collection_success"} {
+		if !names[name] {
+			t.Errorf("expected %s to be a built-in metric", name)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package collector
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/jenningsloy318/vsphere_exporter/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	seriesLimitsMu sync.Mutex
+	seriesLimits   config.SeriesLimits
+
+	seriesDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "series_dropped_total",
+			Help:      "Number of series dropped because a collector or target exceeded its series limit.",
+		},
+		[]string{"collector"},
+	)
+
+	// descFQName extracts the metric name from the description of a desc, which has no accessor
+	descFQName = regexp.MustCompile(`fqName: "([^"]*)"`)
+)
+
+func init() {
+	prometheus.MustRegister(seriesDropped)
+}
+
+// SetSeriesLimits sets the series limits of the config, the scrapes started afterwards apply them
+func SetSeriesLimits(limits config.SeriesLimits) {
+	seriesLimitsMu.Lock()
+	defer seriesLimitsMu.Unlock()
+	seriesLimits = limits
+}
+
+func currentSeriesLimits() config.SeriesLimits {
+	seriesLimitsMu.Lock()
+	defer seriesLimitsMu.Unlock()
+	return seriesLimits
+}
+
+// seriesBudget tracks the series left to a target during a scrape, a negative budget is unlimited
+type seriesBudget struct {
+	logger     *slog.Logger
+	remaining  int
+	collectors map[string]int
+}
+
+// newSeriesBudget returns the budget of a target, targetLimit overrides the per target limit of the config unless it is 0
+func newSeriesBudget(logger *slog.Logger, targetLimit int) *seriesBudget {
+	limits := currentSeriesLimits()
+	if targetLimit == 0 {
+		targetLimit = limits.PerTarget
+	}
+	if targetLimit == 0 {
+		targetLimit = -1
+	}
+	return &seriesBudget{
+		logger:     logger,
+		remaining:  targetLimit,
+		collectors: limits.Collectors,
+	}
+}
+
+// collect sends the series of the collector within the budget of the collector and what is left of the target budget,
+// and returns the number of series sent. The series over budget are dropped in a deterministic order,
+// the last ones by metric name and label values.
+func (b *seriesBudget) collect(name string, collector prometheus.Collector, ch chan<- prometheus.Metric) int {
+	limit := -1
+	if collectorLimit := b.collectors[name]; collectorLimit > 0 {
+		limit = collectorLimit
+	}
+	if b.remaining >= 0 && (limit < 0 || b.remaining < limit) {
+		limit = b.remaining
+	}
+
+	metricCh := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(metricCh)
+		close(metricCh)
+	}()
+	var metrics []prometheus.Metric
+	for metric := range metricCh {
+		metrics = append(metrics, metric)
+	}
+
+	if limit >= 0 && len(metrics) > limit {
+		metrics = b.truncate(name, metrics, limit)
+	}
+	for _, metric := range metrics {
+		ch <- metric
+	}
+	if b.remaining >= 0 {
+		b.remaining -= len(metrics)
+	}
+	return len(metrics)
+}
+
+// truncate keeps the first limit series ordered by metric name and label values, and reports the dropped ones
+func (b *seriesBudget) truncate(name string, metrics []prometheus.Metric, limit int) []prometheus.Metric {
+	keys := make([]string, len(metrics))
+	for i, metric := range metrics {
+		keys[i] = seriesKey(metric)
+	}
+	sort.Sort(seriesByKey{metrics: metrics, keys: keys})
+
+	droppedFamilies := map[string]int{}
+	for _, metric := range metrics[limit:] {
+		droppedFamilies[metricName(metric)]++
+	}
+	var families []string
+	for family, dropped := range droppedFamilies {
+		families = append(families, fmt.Sprintf("%s=%d", family, dropped))
+	}
+	sort.Strings(families)
+	b.logger.Warn("dropped series over the series limit", logging.CollectorKey, name,
+		"dropped", len(metrics)-limit, "series", len(metrics), "limit", limit, "families", strings.Join(families, ", "))
+	seriesDropped.WithLabelValues(name).Add(float64(len(metrics) - limit))
+	return metrics[:limit]
+}
+
+// seriesByKey sorts the series by their key
+type seriesByKey struct {
+	metrics []prometheus.Metric
+	keys    []string
+}
+
+func (s seriesByKey) Len() int           { return len(s.metrics) }
+func (s seriesByKey) Less(i, j int) bool { return s.keys[i] < s.keys[j] }
+func (s seriesByKey) Swap(i, j int) {
+	s.metrics[i], s.metrics[j] = s.metrics[j], s.metrics[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+// seriesKey identifies a series by its metric name and label values
+func seriesKey(metric prometheus.Metric) string {
+	var m dto.Metric
+	if err := metric.Write(&m); err != nil {
+		return metricName(metric)
+	}
+	var key strings.Builder
+	key.WriteString(metricName(metric))
+	for _, label := range m.GetLabel() {
+		key.WriteString("\xff" + label.GetName() + "=" + label.GetValue())
+	}
+	return key.String()
+}
+
+func metricName(metric prometheus.Metric) string {
+	if match := descFQName.FindStringSubmatch(metric.Desc().String()); match != nil {
+		return match[1]
+	}
+	return metric.Desc().String()
+}
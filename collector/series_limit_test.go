@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/jenningsloy318/vsphere_exporter/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var testSeriesDesc = prometheus.NewDesc("vsphere_test_series", "test series", []string{"index"}, nil)
+
+// seriesCollector sends count series, in reverse order of their label
+type seriesCollector int
+
+func (c seriesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- testSeriesDesc
+}
+
+func (c seriesCollector) Collect(ch chan<- prometheus.Metric) {
+	for i := int(c) - 1; i >= 0; i-- {
+		ch <- prometheus.MustNewConstMetric(testSeriesDesc, prometheus.GaugeValue, 1, fmt.Sprintf("%02d", i))
+	}
+}
+
+// collectBudget collects the collectors through a budget and returns the labels of the series sent
+func collectBudget(budget *seriesBudget, collectors map[string]prometheus.Collector, names ...string) []string {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		for _, name := range names {
+			budget.collect(name, collectors[name], ch)
+		}
+		close(ch)
+	}()
+	var labels []string
+	for metric := range ch {
+		var m dto.Metric
+		metric.Write(&m)
+		labels = append(labels, m.GetLabel()[0].GetValue())
+	}
+	return labels
+}
+
+func TestSeriesBudget(t *testing.T) {
+	SetSeriesLimits(config.SeriesLimits{PerTarget: 5, Collectors: map[string]int{"a": 3}})
+	defer SetSeriesLimits(config.SeriesLimits{})
+	collectors := map[string]prometheus.Collector{"a": seriesCollector(4), "b": seriesCollector(4)}
+
+	droppedA := testutil.ToFloat64(seriesDropped.WithLabelValues("a"))
+	droppedB := testutil.ToFloat64(seriesDropped.WithLabelValues("b"))
+	labels := collectBudget(newSeriesBudget(logging.Logger(), 0), collectors, "a", "b")
+	if expected := []string{"00", "01", "02", "00", "01"}; !equalStrings(labels, expected) {
+		t.Errorf("expected series %v, got %v", expected, labels)
+	}
+	if dropped := testutil.ToFloat64(seriesDropped.WithLabelValues("a")) - droppedA; dropped != 1 {
+		t.Errorf("expected 1 series of a dropped, got %v", dropped)
+	}
+	if dropped := testutil.ToFloat64(seriesDropped.WithLabelValues("b")) - droppedB; dropped != 2 {
+		t.Errorf("expected 2 series of b dropped, got %v", dropped)
+	}
+
+	// the limit of the target overrides the per target limit
+	if labels := collectBudget(newSeriesBudget(logging.Logger(), 10), collectors, "a", "b"); len(labels) != 7 {
+		t.Errorf("expected 7 series, got %v", labels)
+	}
+
+	SetSeriesLimits(config.SeriesLimits{})
+	if labels := collectBudget(newSeriesBudget(logging.Logger(), 0), collectors, "a", "b"); len(labels) != 8 {
+		t.Errorf("expected all 8 series without limits, got %v", labels)
+	}
+}
@@ -0,0 +1,43 @@
+package collector
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestCollectionSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	target := newTestVCenter(t, 1)
+	if _, err := scrape(target); err != nil {
+		t.Fatalf("Error when scraping target, %v", err)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	root, ok := spans["collect target"]
+	if !ok {
+		t.Fatalf("Expected a span of the collection of the target, got %d spans", len(spans))
+	}
+	for _, name := range []string{"collector host", "collector vm", "retrieve hosts", "RetrievePropertiesEx"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("Expected a span %q", name)
+			continue
+		}
+		if span.SpanContext().TraceID() != root.SpanContext().TraceID() {
+			t.Errorf("Expected span %q in the trace of the target", name)
+		}
+	}
+	if span, ok := spans["retrieve hosts"]; ok && span.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Errorf("Expected span %q to be a child of the target span", span.Name())
+	}
+}
@@ -1,14 +1,14 @@
 package collector
 
 import (
+	"github.com/jenningsloy318/vsphere_exporter/config"
 	"github.com/jenningsloy318/vsphere_exporter/vmware"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/log"
+	"log/slog"
 )
 
 var (
 	vmSubsystem  = "vm"
-	vmMapping    = map[string]string{}
 	vmLabelNames = []string{"name", "guest", "host"}
 	//vmLabelNames = []string{"category"}
 	vmMetrics = map[string]vmMetric{
@@ -23,9 +23,20 @@ var (
 	}
 )
 
+func init() {
+	registerCollector("vm", true, map[string][]string{
+		"VirtualMachine": {"summary", "config", "guest", "guestHeartbeatStatus", "runtime", "resourcePool"},
+	}, []string{"System.View", "System.Read"}, func(namespace string, source vmware.Source, inventory *vmware.Inventory, filters map[string]*config.FilterConfig, logger *slog.Logger) prometheus.Collector {
+		return NewVmCollector(namespace, source, inventory, filters, logger)
+	})
+}
+
 // A VmCollector implements the prometheus.Collector.
 type VmCollector struct {
-	vsClient              *vmware.VMClient
+	source                vmware.Source
+	inventory             *vmware.Inventory
+	filters               map[string]*config.FilterConfig
+	logger                *slog.Logger
 	metrics               map[string]vmMetric
 	collectorScrapeStatus *prometheus.GaugeVec
 }
@@ -35,13 +46,16 @@ type vmMetric struct {
 }
 
 // NewVmCollector returns a collector that collecting vm statistics
-func NewVmCollector(namespace string, vsClient *vmware.VMClient) *VmCollector {
+func NewVmCollector(namespace string, source vmware.Source, inventory *vmware.Inventory, filters map[string]*config.FilterConfig, logger *slog.Logger) *VmCollector {
 
 	// get service from redfish client
 
 	return &VmCollector{
-		vsClient: vsClient,
-		metrics:  vmMetrics,
+		source:    source,
+		inventory: inventory,
+		filters:   filters,
+		logger:    logger,
+		metrics:   vmMetrics,
 		collectorScrapeStatus: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Namespace: namespace,
@@ -58,24 +72,34 @@ func (v *VmCollector) Describe(ch chan<- *prometheus.Desc) {
 		ch <- metric.desc
 	}
 	v.collectorScrapeStatus.Describe(ch)
+	ch <- filteredObjectsDesc
 
 }
 
 func (v *VmCollector) Collect(ch chan<- prometheus.Metric) {
 	// get a vm list from vsphere client
-	if vmList, err := v.vsClient.ListVirtualMachine(); err != nil {
-		log.Infof("Errors Getting vm list from vsphere : %s", err)
+	if vmList, err := v.source.ListVirtualMachine(); err != nil {
+		v.logger.Error("Errors Getting vm list from vsphere", "err", err)
 	} else {
+		filter := newObjectFilter("vm", "VirtualMachine", v.filters, v.source, v.inventory, v.logger)
 		// process the vm status
 		for _, vm := range vmList {
+			// templates have a config, vms being created may not
+			template := vm.Config != nil && vm.Config.Template
+			if !filter.keepVM(vm.Self, vm.Runtime.Host, vm.ResourcePool, vm.Runtime.PowerState, template) {
+				continue
+			}
 			vmSummary := vm.Summary
-			vmConfig := vm.Config
 			vmQuickStats := vmSummary.QuickStats
-			vmName := vmConfig.Name
-			vmID := vm.ManagedEntity.ExtensibleManagedObject.Self.Value
-			vmMapping[vmID] = vmName
-			vmGuestFullName := vmConfig.GuestFullName
-			vmHost := hostMapping[vmSummary.Runtime.Host.Value]
+			vmName := v.inventory.Name(vm.Self)
+			var vmGuestFullName string
+			if vmConfig := vm.Config; vmConfig != nil {
+				vmGuestFullName = vmConfig.GuestFullName
+			}
+			var vmHost string
+			if vmHostRef := vmSummary.Runtime.Host; vmHostRef != nil {
+				vmHost = v.inventory.Name(*vmHostRef)
+			}
 			vmLabelValues := []string{vmName, vmGuestFullName, vmHost}
 			//
 			vmUptimeValue := float64(vmQuickStats.UptimeSeconds)
@@ -84,6 +108,7 @@ func (v *VmCollector) Collect(ch chan<- prometheus.Metric) {
 
 		}
 
+		filter.collect(ch)
 		v.collectorScrapeStatus.WithLabelValues("virtualmachine").Set(float64(1))
 	}
 }
@@ -0,0 +1,65 @@
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jenningsloy318/vsphere_exporter/logging"
+	"github.com/jenningsloy318/vsphere_exporter/vmware"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// fakeSource serves an inventory built in memory, the methods not overridden panic
+type fakeSource struct {
+	vmware.Source
+	entities []types.ObjectContent
+	vms      []mo.VirtualMachine
+}
+
+func (s *fakeSource) ListEntities() ([]types.ObjectContent, error) {
+	return s.entities, nil
+}
+
+func (s *fakeSource) ListVirtualMachine() ([]mo.VirtualMachine, error) {
+	return s.vms, nil
+}
+
+// newFakeEntity returns the name of an entity as retrieved by ListEntities
+func newFakeEntity(ref types.ManagedObjectReference, name string) types.ObjectContent {
+	return types.ObjectContent{Obj: ref, PropSet: []types.DynamicProperty{{Name: "name", Val: name}}}
+}
+
+func TestVmCollector(t *testing.T) {
+	host := types.ManagedObjectReference{Type: "HostSystem", Value: "host-10"}
+	vm := types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-20"}
+	source := &fakeSource{
+		entities: []types.ObjectContent{newFakeEntity(host, "esx01"), newFakeEntity(vm, "db01")},
+		vms: []mo.VirtualMachine{
+			{
+				ManagedEntity: mo.ManagedEntity{ExtensibleManagedObject: mo.ExtensibleManagedObject{Self: vm}},
+				Config:        &types.VirtualMachineConfigInfo{GuestFullName: "Ubuntu Linux (64-bit)"},
+				Summary: types.VirtualMachineSummary{
+					Runtime:    types.VirtualMachineRuntimeInfo{Host: &host},
+					QuickStats: types.VirtualMachineQuickStats{UptimeSeconds: 86400},
+				},
+			},
+			{
+				// a vm being created has neither config nor host yet
+				ManagedEntity: mo.ManagedEntity{ExtensibleManagedObject: mo.ExtensibleManagedObject{Self: types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-21"}}},
+			},
+		},
+	}
+
+	vmCollector := NewVmCollector(namespace, source, vmware.NewInventory(source), nil, logging.Logger())
+	expected := `
+# HELP vsphere_vm_uptime the virtual machine uptime in seconds
+# TYPE vsphere_vm_uptime gauge
+vsphere_vm_uptime{guest="Ubuntu Linux (64-bit)",host="esx01",name="db01"} 86400
+vsphere_vm_uptime{guest="",host="",name="vm-21"} 0
+`
+	if err := testutil.CollectAndCompare(vmCollector, strings.NewReader(expected), "vsphere_vm_uptime"); err != nil {
+		t.Error(err)
+	}
+}
@@ -2,10 +2,18 @@ package collector
 
 import (
 	"context"
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/jenningsloy318/vsphere_exporter/logging"
+	"github.com/jenningsloy318/vsphere_exporter/tracing"
 	"github.com/jenningsloy318/vsphere_exporter/vmware"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/log"
 	"github.com/vmware/govmomi/vim25/types"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"log/slog"
+	"math"
+	"strings"
 	"time"
 )
 
@@ -21,6 +29,16 @@ const (
 
 // Metric descriptors.
 var (
+	numericStates = kingpin.Flag(
+		"collector.numeric-states",
+		"Expose enumerated states as numeric codes instead of state sets, for compatibility with existing dashboards.",
+	).Default("false").Bool()
+
+	targetInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "target_info"),
+		"information of the scraped target, type is vcenter or esxi",
+		[]string{"type", "version", "build", "api_version"}, nil,
+	)
 	totalScrapeDurationDesc = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, subsystem, "collector_duration_seconds"),
 		"Collector time duration.",
@@ -30,26 +48,59 @@ var (
 
 // Exporter collects redfish metrics. It implements prometheus.Collector.
 type VshpereCollector struct {
-	vsClient   *vmware.VMClient
-	collectors map[string]prometheus.Collector
-	vsherehUp  prometheus.Gauge
+	// ctx carries the span of the collection of the target, which ends once collected
+	ctx         context.Context
+	span        trace.Span
+	logger      *slog.Logger
+	target      string
+	seriesLimit int
+	source      vmware.Source
+	inventory   *vmware.Inventory
+	collectors  map[string]prometheus.Collector
+	vsherehUp   prometheus.Gauge
 }
 
-func NewVshpereCollector(context context.Context, url string, username string, password string) *VshpereCollector {
+// NewVshpereCollector logs into the target, its logs carry the target and a new scrape ID along with the fields of the logger of ctx, if any
+func NewVshpereCollector(context context.Context, target string, clusterConfig *config.ClusterConfig) *VshpereCollector {
 	var collectors map[string]prometheus.Collector
+	var inventory *vmware.Inventory
 
-	vsClient, err := vmware.NewVMClient(context, url, username, password)
+	logger := logging.FromContext(context).With(logging.TargetKey, target, logging.ScrapeIDKey, logging.NewScrapeID())
+	if clusterConfig != nil && clusterConfig.Debug {
+		logger = logging.WithDebug(logger)
+	}
+	ctx, span := tracing.Tracer.Start(logging.NewContext(context, logger), "collect target", trace.WithAttributes(tracing.TargetKey.String(target)))
+	source, err := vmware.NewSource(ctx, target, clusterConfig)
 	if err != nil {
-		log.Errorf("Errors occour when creating vshpere client, %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		logger.Error("Errors occour when creating vshpere client", "err", err)
 	} else {
-		hostCollector := NewHostCollector(namespace, vsClient)
-		vmCollector := NewVmCollector(namespace, vsClient)
-		collectors = map[string]prometheus.Collector{"host": hostCollector, "vm": vmCollector}
+		// the source and inventory live as long as this scrape, so concurrent scrapes never share state,
+		// the collectors asking for the same objects share one retrieval
+		source = vmware.NewCachedSource(source, 0)
+		inventory = vmware.NewInventory(source)
+		var filters map[string]*config.FilterConfig
+		if clusterConfig != nil {
+			filters = clusterConfig.Filters
+		}
+		collectors = newEnabledCollectors(source, inventory, filters, logger)
+	}
+
+	var seriesLimit int
+	if clusterConfig != nil {
+		seriesLimit = clusterConfig.SeriesLimit
 	}
 
 	return &VshpereCollector{
-		vsClient:   vsClient,
-		collectors: collectors,
+		ctx:         ctx,
+		span:        span,
+		logger:      logger,
+		target:      target,
+		seriesLimit: seriesLimit,
+		source:      source,
+		inventory:   inventory,
+		collectors:  collectors,
 		vsherehUp: prometheus.NewGauge(
 			prometheus.GaugeOpts{
 				Namespace: namespace,
@@ -63,6 +114,8 @@ func NewVshpereCollector(context context.Context, url string, username string, p
 
 // Describe implements prometheus.Collector.
 func (r *VshpereCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- targetInfoDesc
+	ch <- privilegeGrantedDesc
 	for _, collector := range r.collectors {
 		collector.Describe(ch)
 	}
@@ -73,19 +126,62 @@ func (r *VshpereCollector) Describe(ch chan<- *prometheus.Desc) {
 func (r *VshpereCollector) Collect(ch chan<- prometheus.Metric) {
 
 	scrapeTime := time.Now()
-	if r.vsClient != nil {
+	if r.source != nil {
 
 		r.vsherehUp.Set(1)
 
-		r.collectors["host"].Collect(ch)
-		r.collectors["vm"].Collect(ch)
+		// vCenter and standalone ESXi hosts are both scraped with the same collectors
+		targetType := "esxi"
+		if r.source.IsVCenter() {
+			targetType = "vcenter"
+		}
+		about := r.source.About()
+		ch <- prometheus.MustNewConstMetric(targetInfoDesc, prometheus.GaugeValue, 1, targetType, about.Version, about.Build, about.ApiVersion)
+
+		r.collectPrivileges(ch)
+		if err := r.inventory.Load(); err != nil {
+			r.logger.Error("Errors occour when retrieving the inventory", "err", err)
+		}
+		// the collectors share the series budget of the target in the order they are collected
+		budget := newSeriesBudget(r.logger, r.seriesLimit)
+		for _, registration := range sortedRegistrations() {
+			if collector, ok := r.collectors[registration.name]; ok {
+				_, span := tracing.Tracer.Start(r.ctx, "collector "+registration.name, trace.WithAttributes(tracing.CollectorKey.String(registration.name)))
+				span.SetAttributes(tracing.SeriesKey.Int(budget.collect(registration.name, collector, ch)))
+				span.End()
+			}
+		}
+		r.source.Logout()
 	} else {
 		r.vsherehUp.Set(0)
 	}
 
 	ch <- r.vsherehUp
 	ch <- prometheus.MustNewConstMetric(totalScrapeDurationDesc, prometheus.GaugeValue, time.Since(scrapeTime).Seconds())
-	r.vsClient.Logout()
+	r.logger.Debug("collected target", "duration_seconds", time.Since(scrapeTime).Seconds())
+	r.span.End()
+}
+
+// collectPrivileges reports whether the account has the privileges the enabled collectors need, the missing ones are logged
+func (r *VshpereCollector) collectPrivileges(ch chan<- prometheus.Metric) {
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	checks, err := CheckPrivileges(r.source, names)
+	if err != nil {
+		r.logger.Error("Errors occour when checking the privileges of the collectors", "err", err)
+		return
+	}
+	for _, check := range checks {
+		var granted float64
+		if check.Granted {
+			granted = 1
+		} else {
+			r.logger.Warn("missing privilege, the collector may return no metrics", logging.CollectorKey, check.Collector, "privilege", check.Privilege)
+		}
+		ch <- prometheus.MustNewConstMetric(privilegeGrantedDesc, prometheus.GaugeValue, granted, check.Collector, check.Privilege)
+	}
 }
 
 func parseOveralStatus(status types.ManagedEntityStatus) float64 {
@@ -146,3 +242,94 @@ func parseHostStandbyMode(standbyMode string) float64 {
 	}
 	return float64(4)
 }
+
+// parseSensorReading scales the reading of a numeric sensor by its unit modifier, and returns the metric reporting its base unit
+func parseSensorReading(sensor types.HostNumericSensorInfo) (string, float64, bool) {
+	value := float64(sensor.CurrentReading) * math.Pow10(int(sensor.UnitModifier))
+	switch strings.ToLower(sensor.BaseUnits) {
+	case "degrees c":
+		return "host_sensor_celsius", value, true
+	case "degrees f":
+		return "host_sensor_celsius", (value - 32) * 5 / 9, true
+	case "degrees k":
+		return "host_sensor_celsius", value - 273.15, true
+	case "rpm":
+		return "host_sensor_rpm", value, true
+	case "volts":
+		return "host_sensor_volts", value, true
+	case "amps", "amperes":
+		return "host_sensor_amperes", value, true
+	case "watts":
+		return "host_sensor_watts", value, true
+	}
+	return "", value, false
+}
+
+// stateMetric describes a metric of an enumerated property. It is exposed as a state set with one series per state,
+// or with --collector.numeric-states as the numeric code it used to have.
+type stateMetric struct {
+	stateSetDesc *prometheus.Desc
+	numericDesc  *prometheus.Desc
+	states       []string
+}
+
+func newStateMetric(fqName string, help string, numericHelp string, labelNames []string, states []string) stateMetric {
+	return stateMetric{
+		stateSetDesc: prometheus.NewDesc(fqName, help+", 1 for the current state and 0 for the others", append(labelNames[:len(labelNames):len(labelNames)], "state"), nil),
+		numericDesc:  prometheus.NewDesc(fqName, numericHelp, labelNames, nil),
+		states:       states,
+	}
+}
+
+func (m stateMetric) describe(ch chan<- *prometheus.Desc) {
+	if *numericStates {
+		ch <- m.numericDesc
+	} else {
+		ch <- m.stateSetDesc
+	}
+}
+
+func (m stateMetric) collect(ch chan<- prometheus.Metric, current string, numericValue float64, labelValues ...string) {
+	if *numericStates {
+		ch <- prometheus.MustNewConstMetric(m.numericDesc, prometheus.GaugeValue, numericValue, labelValues...)
+		return
+	}
+	for _, metric := range newStateSetMetrics(m.stateSetDesc, m.states, current, labelValues...) {
+		ch <- metric
+	}
+}
+
+// parseHealthState returns the lower-cased key of a sensor health state or hardware element status, "unknown" if it is not reported
+func parseHealthState(status types.BaseElementDescription) string {
+	if status == nil {
+		return "unknown"
+	}
+	return strings.ToLower(status.GetElementDescription().Key)
+}
+
+// newStateSetMetrics returns one series per state, the series of the current state is 1 and all others are 0.
+// A current state missing from the states, e.g. added by a newer vSphere release, gets a series of its own.
+// The desc must have "state" as its last variable label.
+func newStateSetMetrics(desc *prometheus.Desc, states []string, current string, labelValues ...string) []prometheus.Metric {
+	metrics := make([]prometheus.Metric, 0, len(states)+1)
+	if current != "" && !containsString(states, current) {
+		states = append(states[:len(states):len(states)], current)
+	}
+	for _, state := range states {
+		var value float64
+		if state == current {
+			value = float64(1)
+		}
+		metrics = append(metrics, prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, append(labelValues[:len(labelValues):len(labelValues)], state)...))
+	}
+	return metrics
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,383 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// newTestVCenter starts a simulated vCenter with the given number of standalone and clustered hosts and returns its address
+func newTestVCenter(t *testing.T, hosts int) string {
+	t.Helper()
+	model := simulator.VPX()
+	model.Host = hosts
+	model.ClusterHost = hosts
+	return startTestModel(t, model, nil)
+}
+
+// startTestModel creates the model, applies setup to its objects and serves it over https
+func startTestModel(t *testing.T, model *simulator.Model, setup func(*simulator.Registry)) string {
+	t.Helper()
+	if err := model.Create(); err != nil {
+		t.Fatalf("Error when creating simulator model, %v", err)
+	}
+	if setup != nil {
+		setup(model.Map())
+	}
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+	t.Cleanup(func() {
+		server.Close()
+		model.Remove()
+	})
+	return server.URL.Host
+}
+
+// newTestCollector returns a collector logging into the target with the simulator credentials
+func newTestCollector(target string) *VshpereCollector {
+	return NewVshpereCollector(context.Background(), target, &config.ClusterConfig{Username: "user", Password: "pass", ConnectTimeout: time.Second})
+}
+
+func scrape(target string) ([]*dto.MetricFamily, error) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newTestCollector(target))
+	return registry.Gather()
+}
+
+func findMetricFamily(metricFamilies []*dto.MetricFamily, name string) *dto.MetricFamily {
+	for _, metricFamily := range metricFamilies {
+		if metricFamily.GetName() == name {
+			return metricFamily
+		}
+	}
+	return nil
+}
+
+func labelValue(metric *dto.Metric, name string) string {
+	for _, label := range metric.GetLabel() {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
+
+func TestVshpereCollectorParallelTargets(t *testing.T) {
+	targets := map[string]int{}
+	for hosts := 1; hosts <= 3; hosts++ {
+		targets[newTestVCenter(t, hosts)] = hosts
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(targets)*3)
+	for target, hosts := range targets {
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func(target string, hosts int) {
+				defer wg.Done()
+				errs <- checkTarget(target, hosts)
+			}(target, hosts)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// checkTarget scrapes the target and verifies that every vm is mapped to a host of the same target
+func checkTarget(target string, hosts int) error {
+	metricFamilies, err := scrape(target)
+	if err != nil {
+		return fmt.Errorf("error when scraping %s, %v", target, err)
+	}
+
+	hostUptime := findMetricFamily(metricFamilies, "vsphere_host_uptime")
+	if hostUptime == nil || len(hostUptime.Metric) != 2*hosts {
+		return fmt.Errorf("target %s: expected %d hosts, got %v", target, 2*hosts, hostUptime)
+	}
+	hostNames := map[string]bool{}
+	for _, metric := range hostUptime.Metric {
+		hostNames[labelValue(metric, "hostname")] = true
+	}
+
+	vmUptime := findMetricFamily(metricFamilies, "vsphere_vm_uptime")
+	if vmUptime == nil || len(vmUptime.Metric) == 0 {
+		return fmt.Errorf("target %s: no vm metrics", target)
+	}
+	for _, metric := range vmUptime.Metric {
+		if host := labelValue(metric, "host"); !hostNames[host] {
+			return fmt.Errorf("target %s: vm %s is mapped to unknown host %q", target, labelValue(metric, "name"), host)
+		}
+	}
+	return nil
+}
+
+func findMetric(metricFamily *dto.MetricFamily, labels map[string]string) *dto.Metric {
+	if metricFamily == nil {
+		return nil
+	}
+	for _, metric := range metricFamily.Metric {
+		matched := true
+		for name, value := range labels {
+			if labelValue(metric, name) != value {
+				matched = false
+			}
+		}
+		if matched {
+			return metric
+		}
+	}
+	return nil
+}
+
+func TestParseSensorReading(t *testing.T) {
+	tests := []struct {
+		sensor types.HostNumericSensorInfo
+		metric string
+		value  float64
+		ok     bool
+	}{
+		{types.HostNumericSensorInfo{CurrentReading: 2350, UnitModifier: -2, BaseUnits: "Degrees C"}, "host_sensor_celsius", 23.5, true},
+		{types.HostNumericSensorInfo{CurrentReading: 212, BaseUnits: "Degrees F"}, "host_sensor_celsius", 100, true},
+		{types.HostNumericSensorInfo{CurrentReading: 5400, BaseUnits: "RPM"}, "host_sensor_rpm", 5400, true},
+		{types.HostNumericSensorInfo{CurrentReading: 1210, UnitModifier: -3, BaseUnits: "Volts"}, "host_sensor_volts", 1.21, true},
+		{types.HostNumericSensorInfo{CurrentReading: 6, UnitModifier: -1, BaseUnits: "Amps"}, "host_sensor_amperes", 0.6, true},
+		{types.HostNumericSensorInfo{CurrentReading: 28, UnitModifier: 1, BaseUnits: "Watts"}, "host_sensor_watts", 280, true},
+		{types.HostNumericSensorInfo{CurrentReading: 0, BaseUnits: ""}, "", 0, false},
+	}
+	for _, test := range tests {
+		metric, value, ok := parseSensorReading(test.sensor)
+		if metric != test.metric || ok != test.ok || math.Abs(value-test.value) > 1e-9 {
+			t.Errorf("%s: expected %s %v %v, got %s %v %v", test.sensor.BaseUnits, test.metric, test.value, test.ok, metric, value, ok)
+		}
+	}
+}
+
+func TestHostSensors(t *testing.T) {
+	target := startTestModel(t, simulator.VPX(), func(registry *simulator.Registry) {
+		for _, entity := range registry.All("HostSystem") {
+			host := entity.(*simulator.HostSystem)
+			if host.Name != "DC0_H0" {
+				continue
+			}
+			host.Runtime.HealthSystemRuntime = &types.HealthSystemRuntime{
+				SystemHealthInfo: &types.HostSystemHealthInfo{
+					NumericSensorInfo: []types.HostNumericSensorInfo{
+						{
+							Name:           "System Board 1 Inlet Temp",
+							Id:             "0.32.0.4",
+							HealthState:    &types.ElementDescription{Key: "green"},
+							CurrentReading: 2350,
+							UnitModifier:   -2,
+							BaseUnits:      "Degrees C",
+							SensorType:     "temperature",
+							TimeStamp:      "2021-06-01T10:00:00Z",
+						},
+						{
+							Name:           "System Board 1 Fan1",
+							Id:             "0.29.0.48",
+							HealthState:    &types.ElementDescription{Key: "Yellow"},
+							CurrentReading: 5400,
+							BaseUnits:      "RPM",
+							SensorType:     "fan",
+						},
+					},
+				},
+			}
+		}
+	})
+
+	metricFamilies, err := scrape(target)
+	if err != nil {
+		t.Fatalf("Error when scraping %s, %v", target, err)
+	}
+
+	temperature := findMetric(findMetricFamily(metricFamilies, "vsphere_host_sensor_celsius"), map[string]string{"hostname": "DC0_H0", "sensor": "System Board 1 Inlet Temp", "sensor_type": "temperature"})
+	if temperature == nil || temperature.GetGauge().GetValue() != 23.5 {
+		t.Fatalf("expected temperature of 23.5, got %v", temperature)
+	}
+	if temperature.GetTimestampMs() != 1622541600000 {
+		t.Errorf("expected the sensor time stamp as sample time, got %d", temperature.GetTimestampMs())
+	}
+
+	fan := findMetric(findMetricFamily(metricFamilies, "vsphere_host_sensor_rpm"), map[string]string{"hostname": "DC0_H0", "sensor": "System Board 1 Fan1"})
+	if fan == nil || fan.GetGauge().GetValue() != 5400 || fan.TimestampMs != nil {
+		t.Errorf("expected fan speed of 5400 without time stamp, got %v", fan)
+	}
+
+	healthState := findMetricFamily(metricFamilies, "vsphere_host_sensor_health_state")
+	for state, expected := range map[string]float64{"unknown": 0, "green": 0, "yellow": 1, "red": 0} {
+		metric := findMetric(healthState, map[string]string{"hostname": "DC0_H0", "sensor": "System Board 1 Fan1", "state": state})
+		if metric == nil || metric.GetGauge().GetValue() != expected {
+			t.Errorf("expected health state %s to be %v, got %v", state, expected, metric)
+		}
+	}
+}
+
+func TestHostHardwareStatus(t *testing.T) {
+	healthSystemRuntime := func(memoryStatus string) *types.HealthSystemRuntime {
+		return &types.HealthSystemRuntime{
+			SystemHealthInfo: &types.HostSystemHealthInfo{
+				NumericSensorInfo: []types.HostNumericSensorInfo{
+					{Name: "Power Supply 1 PS1 Status", HealthState: &types.ElementDescription{Key: "red"}, SensorType: "power"},
+					{Name: "Fan Redundancy", HealthState: &types.ElementDescription{Key: "green"}, SensorType: "fan"},
+					{Name: "Intel Corporation Xeon CPU", HealthState: &types.ElementDescription{Key: "green"}, SensorType: "Processors"},
+				},
+			},
+			HardwareStatusInfo: &types.HostHardwareStatusInfo{
+				MemoryStatusInfo: []types.BaseHostHardwareElementInfo{
+					&types.HostHardwareElementInfo{Name: "Memory Device 1", Status: &types.ElementDescription{Key: memoryStatus}},
+				},
+				CpuStatusInfo: []types.BaseHostHardwareElementInfo{
+					&types.HostHardwareElementInfo{Name: "CPU 1", Status: &types.ElementDescription{Key: "Green"}},
+				},
+				StorageStatusInfo: []types.HostStorageElementInfo{
+					{HostHardwareElementInfo: types.HostHardwareElementInfo{Name: "Disk 0", Status: &types.ElementDescription{Key: "Unknown"}}},
+				},
+			},
+		}
+	}
+
+	target := startTestModel(t, simulator.VPX(), func(registry *simulator.Registry) {
+		for _, entity := range registry.All("HostSystem") {
+			host := entity.(*simulator.HostSystem)
+			switch host.Name {
+			case "DC0_H0":
+				host.Runtime.HealthSystemRuntime = healthSystemRuntime("Yellow")
+			case "DC0_C0_H0":
+				// without healthSystemRuntime the health is sourced from the HostHealthStatusSystem
+				healthStatusSystem := &mo.HostHealthStatusSystem{
+					Self:    types.ManagedObjectReference{Type: "HostHealthStatusSystem", Value: "healthStatusSystem-" + host.Self.Value},
+					Runtime: *healthSystemRuntime("Red"),
+				}
+				registry.Put(healthStatusSystem)
+				host.Runtime.HealthSystemRuntime = nil
+				host.ConfigManager.HealthStatusSystem = &healthStatusSystem.Self
+			}
+		}
+	})
+
+	metricFamilies, err := scrape(target)
+	if err != nil {
+		t.Fatalf("Error when scraping %s, %v", target, err)
+	}
+
+	tests := []struct {
+		metric string
+		labels map[string]string
+		state  string
+	}{
+		{"vsphere_host_memory_hardware_status", map[string]string{"hostname": "DC0_H0", "component": "Memory Device 1"}, "yellow"},
+		{"vsphere_host_memory_hardware_status", map[string]string{"hostname": "DC0_C0_H0", "component": "Memory Device 1"}, "red"},
+		{"vsphere_host_cpu_hardware_status", map[string]string{"hostname": "DC0_H0", "component": "CPU 1"}, "green"},
+		{"vsphere_host_storage_hardware_status", map[string]string{"hostname": "DC0_H0", "component": "Disk 0"}, "unknown"},
+		{"vsphere_host_power_supply_hardware_status", map[string]string{"hostname": "DC0_C0_H0", "component": "Power Supply 1 PS1 Status"}, "red"},
+		{"vsphere_host_fan_hardware_status", map[string]string{"hostname": "DC0_H0", "component": "Fan Redundancy"}, "green"},
+		{"vsphere_host_other_hardware_status", map[string]string{"hostname": "DC0_H0", "component": "Intel Corporation Xeon CPU", "type": "Processors"}, "green"},
+	}
+	for _, test := range tests {
+		metricFamily := findMetricFamily(metricFamilies, test.metric)
+		for _, state := range hostHealthStates {
+			labels := map[string]string{"state": state}
+			for name, value := range test.labels {
+				labels[name] = value
+			}
+			var expected float64
+			if state == test.state {
+				expected = 1
+			}
+			metric := findMetric(metricFamily, labels)
+			if metric == nil || metric.GetGauge().GetValue() != expected {
+				t.Errorf("%s %v: expected %v, got %v", test.metric, labels, expected, metric)
+			}
+		}
+	}
+}
+
+func TestHostStateSets(t *testing.T) {
+	target := newTestVCenter(t, 1)
+
+	metricFamilies, err := scrape(target)
+	if err != nil {
+		t.Fatalf("Error when scraping %s, %v", target, err)
+	}
+	for state, expected := range map[string]float64{"poweredOn": 1, "poweredOff": 0, "standBy": 0, "unknown": 0} {
+		metric := findMetric(findMetricFamily(metricFamilies, "vsphere_host_power_state"), map[string]string{"hostname": "DC0_H0", "state": state})
+		if metric == nil || metric.GetGauge().GetValue() != expected {
+			t.Errorf("expected power state %s to be %v, got %v", state, expected, metric)
+		}
+	}
+	for state, expected := range map[string]float64{"connected": 1, "notResponding": 0, "disconnected": 0} {
+		metric := findMetric(findMetricFamily(metricFamilies, "vsphere_host_connection_state"), map[string]string{"hostname": "DC0_H0", "state": state})
+		if metric == nil || metric.GetGauge().GetValue() != expected {
+			t.Errorf("expected connection state %s to be %v, got %v", state, expected, metric)
+		}
+	}
+
+	*numericStates = true
+	defer func() { *numericStates = false }()
+	metricFamilies, err = scrape(target)
+	if err != nil {
+		t.Fatalf("Error when scraping %s, %v", target, err)
+	}
+	metric := findMetric(findMetricFamily(metricFamilies, "vsphere_host_power_state"), map[string]string{"hostname": "DC0_H0"})
+	if metric == nil || metric.GetGauge().GetValue() != 1 || labelValue(metric, "state") != "" {
+		t.Errorf("expected numeric power state 1, got %v", metric)
+	}
+}
+
+func TestNewStateSetMetricsUnknownState(t *testing.T) {
+	desc := prometheus.NewDesc("test_state", "test", []string{"state"}, nil)
+	metrics := newStateSetMetrics(desc, []string{"green", "red"}, "purple")
+	if len(metrics) != 3 {
+		t.Fatalf("expected a series for the unknown state, got %d series", len(metrics))
+	}
+	var metric dto.Metric
+	if err := metrics[2].Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	if labelValue(&metric, "state") != "purple" || metric.GetGauge().GetValue() != 1 {
+		t.Errorf("expected purple to be the current state, got %v", metric.String())
+	}
+}
+
+func TestESXiTarget(t *testing.T) {
+	target := startTestModel(t, simulator.ESX(), nil)
+
+	metricFamilies, err := scrape(target)
+	if err != nil {
+		t.Fatalf("Error when scraping %s, %v", target, err)
+	}
+	if metric := findMetric(findMetricFamily(metricFamilies, "vsphere_target_info"), map[string]string{"type": "esxi"}); metric == nil {
+		t.Errorf("expected an esxi target, got %v", findMetricFamily(metricFamilies, "vsphere_target_info"))
+	}
+	hostUptime := findMetricFamily(metricFamilies, "vsphere_host_uptime")
+	if hostUptime == nil || len(hostUptime.Metric) != 1 {
+		t.Fatalf("expected 1 host, got %v", hostUptime)
+	}
+	hostName := labelValue(hostUptime.Metric[0], "hostname")
+	vmUptime := findMetricFamily(metricFamilies, "vsphere_vm_uptime")
+	if vmUptime == nil || len(vmUptime.Metric) == 0 {
+		t.Fatalf("expected vm metrics")
+	}
+	for _, metric := range vmUptime.Metric {
+		if host := labelValue(metric, "host"); host != hostName {
+			t.Errorf("expected vm %s on host %s, got %s", labelValue(metric, "name"), hostName, host)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jenningsloy318/vsphere_exporter/collector"
+	"github.com/jenningsloy318/vsphere_exporter/logging"
+	"github.com/jenningsloy318/vsphere_exporter/vmware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/vmware/govmomi/vim25/types"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+// The commands other than serve run once against a target for troubleshooting, they print to stdout and log to stderr
+var (
+	serveCommand = kingpin.Command("serve", "Serve the metrics of the targets over HTTP, the default command.").Default()
+
+	scrapeCommand = kingpin.Command("scrape", "Scrape a target once and print its metrics in the text exposition format.")
+	scrapeTarget  = scrapeCommand.Flag("target", "Target to scrape, its credentials are resolved from the config file.").Required().String()
+
+	inventoryCommand    = kingpin.Command("inventory", "Print the managed objects of a type retrieved from a target.")
+	inventoryTarget     = inventoryCommand.Flag("target", "Target to retrieve the objects from, its credentials are resolved from the config file.").Required().String()
+	inventoryType       = inventoryCommand.Flag("type", "Managed object type, e.g. HostSystem, VirtualMachine or Datastore.").Default("HostSystem").String()
+	inventoryProperties = inventoryCommand.Flag("property", "Property path to retrieve, e.g. summary.hardware, can be repeated, all properties are retrieved if none is given.").Strings()
+	inventoryOutput     = inventoryCommand.Flag("output", "Output format, text or json.").Short('o').Default("text").Enum("text", "json")
+
+	countersCommand = kingpin.Command("counters", "Print the perf counters of a target with their units, levels and rollups.")
+	countersTarget  = countersCommand.Flag("target", "Target to retrieve the perf counters from, its credentials are resolved from the config file.").Required().String()
+	countersOutput  = countersCommand.Flag("output", "Output format, text or json.").Short('o').Default("text").Enum("text", "json")
+
+	checkPermissionsCommand = kingpin.Command("check-permissions", "Check that the account of a target has the privileges the enabled collectors need, it fails if any is missing.")
+	checkPermissionsTarget  = checkPermissionsCommand.Flag("target", "Target to check the privileges on, its credentials are resolved from the config file.").Required().String()
+	checkPermissionsOutput  = checkPermissionsCommand.Flag("output", "Output format, text or json.").Short('o').Default("text").Enum("text", "json")
+)
+
+var errTargetDown = errors.New("target is down, see the logs for the cause")
+
+// runCommand runs a one-shot command with the config file loaded, the HTTP server isn't started
+func runCommand(command string) error {
+	if err := loadConfig(); err != nil {
+		return fmt.Errorf("error parsing config file, %v", err)
+	}
+	ctx := context.Background()
+	switch command {
+	case scrapeCommand.FullCommand():
+		return runScrape(ctx, os.Stdout, *scrapeTarget)
+	case inventoryCommand.FullCommand():
+		return runInventory(ctx, os.Stdout, *inventoryTarget, *inventoryType, *inventoryProperties, *inventoryOutput)
+	case countersCommand.FullCommand():
+		return runCounters(ctx, os.Stdout, *countersTarget, *countersOutput)
+	case checkPermissionsCommand.FullCommand():
+		return runCheckPermissions(ctx, os.Stdout, *checkPermissionsTarget, *checkPermissionsOutput)
+	}
+	return fmt.Errorf("unknown command %s", command)
+}
+
+// runScrape collects the target with the enabled collectors, as a scrape of /vsphere does, and writes its metrics.
+// It fails if the target is down, the metrics are written anyway.
+func runScrape(ctx context.Context, w io.Writer, target string) error {
+	clusterConfig, module, err := sc.ResolveTarget(target)
+	if err != nil {
+		return err
+	}
+	registry := prometheus.NewRegistry()
+	ctx = logging.NewContext(ctx, logging.Logger().With(logging.ModuleKey, module))
+	if err := registry.Register(collector.NewVshpereCollector(ctx, target, clusterConfig)); err != nil {
+		return err
+	}
+	metricFamilies, err := registry.Gather()
+	if err != nil {
+		return err
+	}
+	encoder := expfmt.NewEncoder(w, expfmt.FmtText)
+	up := true
+	for _, metricFamily := range metricFamilies {
+		if err := encoder.Encode(metricFamily); err != nil {
+			return err
+		}
+		if metricFamily.GetName() == "vsphere_up" && metricFamily.GetMetric()[0].GetGauge().GetValue() == 0 {
+			up = false
+		}
+	}
+	if !up {
+		return errTargetDown
+	}
+	return nil
+}
+
+// newCommandSource logs into the target, or opens its recording with --replay.dir
+func newCommandSource(ctx context.Context, target string) (vmware.Source, error) {
+	clusterConfig, module, err := sc.ResolveTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	logger := logging.Logger().With(logging.TargetKey, target, logging.ModuleKey, module)
+	return vmware.NewSource(logging.NewContext(ctx, logger), target, clusterConfig)
+}
+
+// inventoryObject is a managed object printed by the inventory command
+type inventoryObject struct {
+	Ref        string                 `json:"ref"`
+	Name       string                 `json:"name,omitempty"`
+	Path       string                 `json:"path,omitempty"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// runInventory writes the properties of the managed objects of the type, ordered by inventory path
+func runInventory(ctx context.Context, w io.Writer, target string, objectType string, properties []string, output string) error {
+	source, err := newCommandSource(ctx, target)
+	if err != nil {
+		return err
+	}
+	defer source.Logout()
+	objectList, err := source.RetrieveProperties(objectType, properties)
+	if err != nil {
+		return err
+	}
+	// the dump is shared like the recordings, so it is scrubbed of secrets the same way
+	vmware.Scrub(&objectList)
+	inventory := vmware.NewInventory(source)
+	if err := inventory.Load(); err != nil {
+		return err
+	}
+
+	objects := make([]inventoryObject, 0, len(objectList))
+	for _, object := range objectList {
+		item := inventoryObject{
+			Ref:        object.Obj.String(),
+			Name:       inventory.Name(object.Obj),
+			Path:       inventory.Path(object.Obj),
+			Properties: map[string]interface{}{},
+		}
+		for _, property := range object.PropSet {
+			item.Properties[property.Name] = property.Val
+		}
+		objects = append(objects, item)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].Path != objects[j].Path {
+			return objects[i].Path < objects[j].Path
+		}
+		return objects[i].Ref < objects[j].Ref
+	})
+
+	if output == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(objects)
+	}
+	for _, object := range objects {
+		fmt.Fprintf(w, "%s %s\n", object.Ref, object.Path)
+		names := make([]string, 0, len(object.Properties))
+		for name := range object.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "  %s: %+v\n", name, object.Properties[name])
+		}
+	}
+	return nil
+}
+
+// perfCounter is a perf counter printed by the counters command
+type perfCounter struct {
+	Name           string `json:"name"`
+	Key            int32  `json:"key"`
+	Unit           string `json:"unit"`
+	Level          int32  `json:"level"`
+	PerDeviceLevel int32  `json:"per_device_level"`
+	Rollup         string `json:"rollup"`
+	StatsType      string `json:"stats_type"`
+	Description    string `json:"description"`
+}
+
+// runCounters writes the perf counter catalogue of the target ordered by counter name
+func runCounters(ctx context.Context, w io.Writer, target string, output string) error {
+	source, err := newCommandSource(ctx, target)
+	if err != nil {
+		return err
+	}
+	defer source.Logout()
+	perfCounters, err := source.ListPerfCounters()
+	if err != nil {
+		return err
+	}
+
+	counters := make([]perfCounter, 0, len(perfCounters))
+	for name, info := range perfCounters {
+		counters = append(counters, perfCounter{
+			Name:           name,
+			Key:            info.Key,
+			Unit:           elementKey(info.UnitInfo),
+			Level:          info.Level,
+			PerDeviceLevel: info.PerDeviceLevel,
+			Rollup:         string(info.RollupType),
+			StatsType:      string(info.StatsType),
+			Description:    elementSummary(info.NameInfo),
+		})
+	}
+	sort.Slice(counters, func(i, j int) bool { return counters[i].Name < counters[j].Name })
+
+	if output == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(counters)
+	}
+	table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(table, "NAME\tKEY\tUNIT\tLEVEL\tPER DEVICE LEVEL\tROLLUP\tSTATS\tDESCRIPTION")
+	for _, counter := range counters {
+		fmt.Fprintf(table, "%s\t%d\t%s\t%d\t%d\t%s\t%s\t%s\n", counter.Name, counter.Key, counter.Unit, counter.Level, counter.PerDeviceLevel, counter.Rollup, counter.StatsType, counter.Description)
+	}
+	return table.Flush()
+}
+
+// runCheckPermissions writes whether the account has each privilege the enabled collectors need, it fails with the missing ones
+func runCheckPermissions(ctx context.Context, w io.Writer, target string, output string) error {
+	source, err := newCommandSource(ctx, target)
+	if err != nil {
+		return err
+	}
+	defer source.Logout()
+	checks, err := collector.CheckPrivileges(source, collector.EnabledCollectors())
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(checks)
+	} else {
+		table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(table, "COLLECTOR\tPRIVILEGE\tGRANTED")
+		for _, check := range checks {
+			fmt.Fprintf(table, "%s\t%s\t%t\n", check.Collector, check.Privilege, check.Granted)
+		}
+		err = table.Flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, check := range checks {
+		if !check.Granted {
+			missing = append(missing, fmt.Sprintf("%s of collector %s", check.Privilege, check.Collector))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing privileges on the root folder: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func elementKey(description types.BaseElementDescription) string {
+	if description == nil {
+		return ""
+	}
+	return description.GetElementDescription().Key
+}
+
+func elementSummary(description types.BaseElementDescription) string {
+	if description == nil {
+		return ""
+	}
+	return description.GetElementDescription().Summary
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jenningsloy318/vsphere_exporter/collector"
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// setTestConfig sets the credentials of the simulator as the default credentials until the test ends
+func setTestConfig(t *testing.T) {
+	t.Helper()
+	previous := sc.C
+	sc.C = &config.Config{Mode: "multi", Clusters: map[string]config.ClusterConfig{"default": {Username: "user", Password: "pass"}}}
+	t.Cleanup(func() { sc.C = previous })
+}
+
+func TestScrapeCommand(t *testing.T) {
+	target := startTestESXi(t)
+	setTestConfig(t)
+
+	var out bytes.Buffer
+	if err := runScrape(context.Background(), &out, target); err != nil {
+		t.Fatalf("Error when scraping target, %v", err)
+	}
+	golden, err := os.Open(goldenESXi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer golden.Close()
+	if err := testutil.GatherAndCompare(textGatherer(t, &out), golden, goldenNames(t)...); err != nil {
+		t.Error(err)
+	}
+
+	if err := runScrape(context.Background(), &bytes.Buffer{}, "127.0.0.1:1"); err != errTargetDown {
+		t.Errorf("Expected an unreachable target to be reported down, got %v", err)
+	}
+}
+
+func TestInventoryCommand(t *testing.T) {
+	target := startTestESXi(t)
+	setTestConfig(t)
+
+	var out bytes.Buffer
+	if err := runInventory(context.Background(), &out, target, "HostSystem", []string{"summary.hardware.vendor"}, "json"); err != nil {
+		t.Fatalf("Error when retrieving the inventory, %v", err)
+	}
+	var objects []inventoryObject
+	if err := json.Unmarshal(out.Bytes(), &objects); err != nil {
+		t.Fatalf("Error when parsing the inventory, %v", err)
+	}
+	if len(objects) != 1 {
+		t.Fatalf("Expected the host of the simulated ESXi, got %d objects", len(objects))
+	}
+	host := objects[0]
+	if !strings.HasPrefix(host.Ref, "HostSystem:") || host.Name == "" || !strings.HasSuffix(host.Path, "/"+host.Name) {
+		t.Errorf("Expected the reference, name and path of the host, got %+v", host)
+	}
+	if host.Properties["summary.hardware.vendor"] != "VMware, Inc." {
+		t.Errorf("Expected the vendor of the host, got %v", host.Properties)
+	}
+
+	out.Reset()
+	if err := runInventory(context.Background(), &out, target, "HostSystem", []string{"summary.hardware.vendor"}, "text"); err != nil {
+		t.Fatalf("Error when retrieving the inventory, %v", err)
+	}
+	if expected := host.Ref + " " + host.Path + "\n  summary.hardware.vendor: VMware, Inc.\n"; out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestInventoryCommandScrubbed(t *testing.T) {
+	model := simulator.ESX()
+	if err := model.Create(); err != nil {
+		t.Fatalf("Error when creating simulator model, %v", err)
+	}
+	for _, entity := range model.Map().All("VirtualMachine") {
+		vm := entity.(*simulator.VirtualMachine)
+		vm.Config.ExtraConfig = append(vm.Config.ExtraConfig, &types.OptionValue{Key: "guestinfo.password", Value: "s3cret"})
+	}
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+	defer model.Remove()
+	defer server.Close()
+	setTestConfig(t)
+
+	var out bytes.Buffer
+	if err := runInventory(context.Background(), &out, server.URL.Host, "VirtualMachine", []string{"config.extraConfig"}, "json"); err != nil {
+		t.Fatalf("Error when retrieving the inventory, %v", err)
+	}
+	if !strings.Contains(out.String(), "guestinfo.password") || strings.Contains(out.String(), "s3cret") {
+		t.Errorf("Expected the inventory to be scrubbed of secrets, got %s", out.String())
+	}
+}
+
+func TestCountersCommand(t *testing.T) {
+	target := startTestESXi(t)
+	setTestConfig(t)
+
+	var out bytes.Buffer
+	if err := runCounters(context.Background(), &out, target, "json"); err != nil {
+		t.Fatalf("Error when retrieving the perf counters, %v", err)
+	}
+	var counters []perfCounter
+	if err := json.Unmarshal(out.Bytes(), &counters); err != nil {
+		t.Fatalf("Error when parsing the perf counters, %v", err)
+	}
+	var usage *perfCounter
+	for i := range counters {
+		if counters[i].Name == "cpu.usage.average" {
+			usage = &counters[i]
+		}
+	}
+	if usage == nil {
+		t.Fatalf("Expected counter cpu.usage.average among %d counters", len(counters))
+	}
+	if usage.Unit != "percent" || usage.Rollup != "average" || usage.StatsType != "rate" {
+		t.Errorf("Expected the unit, rollup and stats type of cpu.usage.average, got %+v", *usage)
+	}
+
+	out.Reset()
+	if err := runCounters(context.Background(), &out, target, "text"); err != nil {
+		t.Fatalf("Error when retrieving the perf counters, %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != len(counters)+1 || !strings.HasPrefix(lines[0], "NAME") {
+		t.Errorf("Expected a header and a line per counter, got %d lines", len(lines))
+	}
+}
+
+func TestCheckPermissionsCommand(t *testing.T) {
+	target := startTestESXi(t)
+	setTestConfig(t)
+
+	var out bytes.Buffer
+	if err := runCheckPermissions(context.Background(), &out, target, "json"); err != nil {
+		t.Fatalf("Error when checking the privileges, %v", err)
+	}
+	var checks []collector.PrivilegeCheck
+	if err := json.Unmarshal(out.Bytes(), &checks); err != nil {
+		t.Fatalf("Error when parsing the privileges, %v", err)
+	}
+	if len(checks) == 0 {
+		t.Fatal("Expected the privileges of the enabled collectors")
+	}
+	for _, check := range checks {
+		// the simulator grants every privilege
+		if !check.Granted {
+			t.Errorf("Expected privilege %s of collector %s to be granted", check.Privilege, check.Collector)
+		}
+	}
+}
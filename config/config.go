@@ -1,27 +1,383 @@
 package config
 
 import (
+	"errors"
 	"fmt"
-	"github.com/prometheus/common/log"
+	"github.com/jenningsloy318/vsphere_exporter/logging"
 	yaml "gopkg.in/yaml.v2"
 	"io/ioutil"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Config struct {
 	Mode           string                   `yaml:"mode"`
 	EnabledCluster string                   `yaml:"enabled_cluster"`
 	Clusters       map[string]ClusterConfig `yaml:"clusters"`
+	// Credentials are matched in order against the targets not listed in clusters, before falling back to the default credentials
+	Credentials []CredentialRule `yaml:"credentials,omitempty"`
+	// ListedTargetsOnly rejects the targets neither listed in clusters nor matched by credentials instead of scraping them with the default credentials
+	ListedTargetsOnly bool `yaml:"listed_targets_only,omitempty"`
+	// CustomMetrics are exported from the properties of the managed objects without code changes
+	CustomMetrics []CustomMetric `yaml:"custom_metrics,omitempty"`
+	// Filters select the vms, hosts and datastores metrics are exported for, keyed by "vm", "host" or "datastore",
+	// the filters of a cluster or credential rule override them
+	Filters map[string]*FilterConfig `yaml:"filters,omitempty"`
+	// SeriesLimits bound the series exported per scrape, so a single target can't overwhelm Prometheus
+	SeriesLimits SeriesLimits `yaml:"series_limits,omitempty"`
+	// Callers restricts the targets each basic auth user of the web config file can scrape, unlisted users get the "default" entry or are denied
+	Callers map[string]CallerConfig `yaml:"callers,omitempty"`
 }
 
+// SeriesLimits are the series budgets of the collectors, 0 means unlimited
+type SeriesLimits struct {
+	// PerTarget bounds the series of all collectors of a target, the series_limit of a cluster overrides it
+	PerTarget int `yaml:"per_target,omitempty"`
+	// Collectors bound the series of each collector of a target, keyed by collector name
+	Collectors map[string]int `yaml:"collectors,omitempty"`
+}
+
+type CallerConfig struct {
+	// Targets the caller can scrape, "all" allows the scrape of every cluster at once
+	Targets []string `yaml:"targets,omitempty"`
+	// Modules the caller can scrape the targets of, e.g. "credentials[1]" allows every target matching the second credential rule
+	Modules []string `yaml:"modules,omitempty"`
+}
+
+// CredentialRule gives the cluster config of the targets matching exactly one of glob, regex or cidr
+type CredentialRule struct {
+	// Glob matches the target as written, e.g. "esx-*.site1.example.com"
+	Glob string `yaml:"glob,omitempty"`
+	// Regex matches the whole target, e.g. `esx[0-9]+\.site2\.example\.com`
+	Regex string `yaml:"regex,omitempty"`
+	// CIDR matches the address of targets given by IP, e.g. "10.36.0.0/16"
+	CIDR          string `yaml:"cidr,omitempty"`
+	ClusterConfig `yaml:",inline"`
+
+	regex   *regexp.Regexp
+	network *net.IPNet
+}
+
+// compile validates the rule and prepares its matcher
+func (rule *CredentialRule) compile() error {
+	matchers := 0
+	for _, matcher := range []string{rule.Glob, rule.Regex, rule.CIDR} {
+		if matcher != "" {
+			matchers++
+		}
+	}
+	if matchers != 1 {
+		return fmt.Errorf("exactly one of glob, regex or cidr must be set")
+	}
+	var err error
+	switch {
+	case rule.Glob != "":
+		_, err = path.Match(rule.Glob, "")
+	case rule.Regex != "":
+		rule.regex, err = regexp.Compile("^(?:" + rule.Regex + ")$")
+	case rule.CIDR != "":
+		_, rule.network, err = net.ParseCIDR(rule.CIDR)
+	}
+	return err
+}
+
+// matches reports whether the rule applies to the target
+func (rule *CredentialRule) matches(target string) bool {
+	switch {
+	case rule.Glob != "":
+		matched, _ := path.Match(rule.Glob, target)
+		return matched
+	case rule.regex != nil:
+		return rule.regex.MatchString(target)
+	case rule.network != nil:
+		ip := net.ParseIP(targetHost(target))
+		return ip != nil && rule.network.Contains(ip)
+	}
+	return false
+}
+
+// String describes the matcher of the rule, e.g. "cidr 10.36.0.0/16"
+func (rule *CredentialRule) String() string {
+	switch {
+	case rule.Glob != "":
+		return "glob " + rule.Glob
+	case rule.Regex != "":
+		return "regex " + rule.Regex
+	default:
+		return "cidr " + rule.CIDR
+	}
+}
+
+// targetHost returns the host of a target, which is a host, a host and port, an IPv6 address or a URL
+func targetHost(target string) string {
+	if strings.Contains(target, "://") {
+		if targetURL, err := url.Parse(target); err == nil {
+			return targetURL.Hostname()
+		}
+		return target
+	}
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		return host
+	}
+	return strings.Trim(target, "[]")
+}
+
+// CustomMetric exports a property of every managed object of a type, e.g. summary.quickStats.overallCpuUsage of HostSystem,
+// as vsphere_<name>{name="<object name>", <labels>...}
+type CustomMetric struct {
+	Name string `yaml:"name"`
+	Help string `yaml:"help,omitempty"`
+	// Type is gauge or counter, gauge by default
+	Type string `yaml:"type,omitempty"`
+	// Object is the managed object type, e.g. HostSystem, VirtualMachine, Datastore or ClusterComputeResource
+	Object string `yaml:"object"`
+	// Property is the path of the value, e.g. config.hardware.numCPU
+	Property string `yaml:"property"`
+	// Labels are set from other properties of the object
+	Labels []CustomLabel `yaml:"labels,omitempty"`
+	// ValueMapping maps the values of enums and booleans, e.g. poweredOn: 1, an unmapped value isn't exported.
+	// Booleans are 1 for true and 0 for false without mapping.
+	ValueMapping map[string]float64 `yaml:"value_mapping,omitempty"`
+}
+
+type CustomLabel struct {
+	Name     string `yaml:"name"`
+	Property string `yaml:"property"`
+}
+
+// customMetricObjects are the managed object types custom metrics can be defined for
+var customMetricObjects = []string{"HostSystem", "VirtualMachine", "Datastore", "ClusterComputeResource", "Network", "Datacenter", "ResourcePool"}
+
+var metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// validate checks the custom metric, the name label is reserved for the object name, and the names of the built-in metrics
+// and of the exporter's own metrics, vsphere_exporter_*, are reserved for them
+func (m *CustomMetric) validate(builtinMetricNames []string) error {
+	if !metricNameRE.MatchString(m.Name) {
+		return fmt.Errorf("invalid metric name %q", m.Name)
+	}
+	if containsString(builtinMetricNames, "vsphere_"+m.Name) || strings.HasPrefix(m.Name, "exporter_") {
+		return fmt.Errorf("metric name %q is used by a built-in metric", m.Name)
+	}
+	if m.Type != "" && m.Type != "gauge" && m.Type != "counter" {
+		return fmt.Errorf("metric %s: invalid type %q, must be gauge or counter", m.Name, m.Type)
+	}
+	if !containsString(customMetricObjects, m.Object) {
+		return fmt.Errorf("metric %s: invalid object %q, must be one of %s", m.Name, m.Object, strings.Join(customMetricObjects, ", "))
+	}
+	if m.Property == "" {
+		return fmt.Errorf("metric %s: missing property", m.Name)
+	}
+	labelNames := []string{"name"}
+	for _, label := range m.Labels {
+		if !metricNameRE.MatchString(label.Name) || strings.Contains(label.Name, ":") {
+			return fmt.Errorf("metric %s: invalid label name %q", m.Name, label.Name)
+		}
+		if containsString(labelNames, label.Name) {
+			return fmt.Errorf("metric %s: duplicate label %q", m.Name, label.Name)
+		}
+		if label.Property == "" {
+			return fmt.Errorf("metric %s: missing property of label %s", m.Name, label.Name)
+		}
+		labelNames = append(labelNames, label.Name)
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterConfig selects the objects of a type, an object is kept if it matches the include rules and none of the exclude rules.
+// VM templates are excluded unless templates is set.
+type FilterConfig struct {
+	Include   *FilterRules `yaml:"include,omitempty"`
+	Exclude   *FilterRules `yaml:"exclude,omitempty"`
+	Templates bool         `yaml:"templates,omitempty"`
+}
+
+// FilterRules match an object by its name, folder, cluster, resource pool, tags or power state.
+// Each rule matches if any of its values does.
+type FilterRules struct {
+	// Names are regular expressions matching the whole name, e.g. "srv-.*"
+	Names []string `yaml:"names,omitempty"`
+	// Folders are inventory paths matching the objects below them, e.g. "/DC0/vm/servers"
+	Folders []string `yaml:"folders,omitempty"`
+	// Clusters are the names of the clusters of the hosts and vms
+	Clusters []string `yaml:"clusters,omitempty"`
+	// ResourcePools are the names of the resource pools of the vms
+	ResourcePools []string `yaml:"resource_pools,omitempty"`
+	// Tags are "<category>/<tag>" or a tag name of any category
+	Tags []string `yaml:"tags,omitempty"`
+	// PowerStates are the power states of the hosts and vms, e.g. poweredOn
+	PowerStates []string `yaml:"power_states,omitempty"`
+
+	names []*regexp.Regexp
+}
+
+// FilterObject describes an object to filter, the properties which don't apply to its type are empty
+type FilterObject struct {
+	Name         string
+	Path         string
+	Cluster      string
+	ResourcePool string
+	Tags         []string
+	PowerState   string
+	Template     bool
+}
+
+// filterTypes are the object types filters can be defined for, with the rules not applying to them
+var filterTypes = map[string][]string{
+	"vm":        {},
+	"host":      {"resource_pools"},
+	"datastore": {"clusters", "resource_pools", "power_states"},
+}
+
+func (rules *FilterRules) compile(objectType string) error {
+	for _, name := range rules.Names {
+		regex, err := regexp.Compile("^(?:" + name + ")$")
+		if err != nil {
+			return err
+		}
+		rules.names = append(rules.names, regex)
+	}
+	set := map[string]bool{"clusters": len(rules.Clusters) > 0, "resource_pools": len(rules.ResourcePools) > 0, "power_states": len(rules.PowerStates) > 0}
+	for _, rule := range filterTypes[objectType] {
+		if set[rule] {
+			return fmt.Errorf("%s don't apply to %s", rule, objectType)
+		}
+	}
+	return nil
+}
+
+// matches returns the rules matched by the object, and how many rules are set
+func (rules *FilterRules) matches(object FilterObject) (int, int) {
+	var matched, set int
+	check := func(values []string, match func(string) bool) {
+		if len(values) == 0 {
+			return
+		}
+		set++
+		for _, value := range values {
+			if match(value) {
+				matched++
+				return
+			}
+		}
+	}
+	check(rules.Names, func(string) bool {
+		for _, regex := range rules.names {
+			if regex.MatchString(object.Name) {
+				return true
+			}
+		}
+		return false
+	})
+	check(rules.Folders, func(folder string) bool {
+		return strings.HasPrefix(object.Path, strings.TrimSuffix(folder, "/")+"/")
+	})
+	check(rules.Clusters, func(cluster string) bool { return cluster == object.Cluster })
+	check(rules.ResourcePools, func(resourcePool string) bool { return resourcePool == object.ResourcePool })
+	check(rules.Tags, func(tag string) bool {
+		for _, objectTag := range object.Tags {
+			if tag == objectTag || (!strings.Contains(tag, "/") && strings.HasSuffix(objectTag, "/"+tag)) {
+				return true
+			}
+		}
+		return false
+	})
+	check(rules.PowerStates, func(powerState string) bool { return powerState == object.PowerState })
+	return matched, set
+}
+
+// Keep reports whether metrics are exported for the object, it must match every include rule and no exclude rule
+func (f *FilterConfig) Keep(object FilterObject) bool {
+	if object.Template && (f == nil || !f.Templates) {
+		return false
+	}
+	if f == nil {
+		return true
+	}
+	if f.Include != nil {
+		if matched, set := f.Include.matches(object); matched < set {
+			return false
+		}
+	}
+	if f.Exclude != nil {
+		if matched, _ := f.Exclude.matches(object); matched > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// UsesTags reports whether the filter needs the tags of the objects
+func (f *FilterConfig) UsesTags() bool {
+	return f != nil && ((f.Include != nil && len(f.Include.Tags) > 0) || (f.Exclude != nil && len(f.Exclude.Tags) > 0))
+}
+
+// compileFilters validates the filters and prepares their rules, the error starts with the object type of the invalid filter, e.g. "[vm], "
+func compileFilters(filters map[string]*FilterConfig) error {
+	for objectType, filter := range filters {
+		if _, ok := filterTypes[objectType]; !ok {
+			return fmt.Errorf("[%s], unknown object type, must be vm, host or datastore", objectType)
+		}
+		if filter == nil {
+			continue
+		}
+		for _, rules := range []*FilterRules{filter.Include, filter.Exclude} {
+			if rules == nil {
+				continue
+			}
+			if err := rules.compile(objectType); err != nil {
+				return fmt.Errorf("[%s], %v", objectType, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ErrTargetNotListed is returned for a target not listed in clusters when only listed targets can be scraped
+var ErrTargetNotListed = errors.New("target is not listed in the config")
+
 type SafeConfig struct {
 	sync.RWMutex
 	C *Config
+	// BuiltinMetricNames are the names of the metrics of the collectors, e.g. vsphere_host_uptime, custom metrics can't reuse them
+	BuiltinMetricNames []string
+	// BasicAuthUsers tells whether basic auth users are set in the web config file, callers can't be restricted without them
+	BasicAuthUsers bool
 }
 
 type ClusterConfig struct {
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
+	// URL overrides the address of the target, e.g. https://10.36.51.11:8443/sdk, it has the same syntax as the target
+	URL string `yaml:"url,omitempty"`
+	// ProxyURL is the proxy the target is reached through, http:// or https:// for HTTP CONNECT, socks5:// for SOCKS5
+	ProxyURL string `yaml:"proxy_url,omitempty"`
+	// ConnectTimeout limits the TCP connect and TLS handshake, 30s by default
+	ConnectTimeout time.Duration `yaml:"connect_timeout,omitempty"`
+	// KeepAlive is the TCP keep-alive period of the connections, 30s by default, a negative value disables it
+	KeepAlive time.Duration `yaml:"keep_alive,omitempty"`
+	// PollInterval is the interval the target is collected at in poll mode, --poll.interval by default
+	PollInterval time.Duration `yaml:"poll_interval,omitempty"`
+	// SeriesLimit overrides the per target series limit of series_limits, 0 keeps it
+	SeriesLimit int `yaml:"series_limit,omitempty"`
+	// Debug logs the scrapes of the target at debug level whatever --log.level
+	Debug bool `yaml:"debug,omitempty"`
+	// Filters override the filters of the config for the object types they are given for, the targets resolved here are scraped with them
+	Filters map[string]*FilterConfig `yaml:"filters,omitempty"`
 }
 
 func (sc *SafeConfig) ReloadConfig(configFile string) error {
@@ -29,19 +385,64 @@ func (sc *SafeConfig) ReloadConfig(configFile string) error {
 
 	yamlFile, err := ioutil.ReadFile(configFile)
 	if err != nil {
-		log.Errorf("Error reading config file: %s", err)
+		logging.Logger().Error("Error reading config file", "file", configFile, "err", err)
 		return err
 	}
 	if err := yaml.Unmarshal(yamlFile, c); err != nil {
-		log.Errorf("Error parsing config file: %s", err)
+		logging.Logger().Error("Error parsing config file", "file", configFile, "err", err)
 		return err
 	}
+	for i := range c.Credentials {
+		if err := c.Credentials[i].compile(); err != nil {
+			logging.Logger().Error("Error parsing config file", "file", configFile, "entry", fmt.Sprintf("credentials[%d]", i), "err", err)
+			return fmt.Errorf("credentials[%d], %v", i, err)
+		}
+	}
+	customMetricNames := map[string]bool{}
+	for i := range c.CustomMetrics {
+		err := c.CustomMetrics[i].validate(sc.BuiltinMetricNames)
+		if err == nil && customMetricNames[c.CustomMetrics[i].Name] {
+			err = fmt.Errorf("duplicate metric name %q", c.CustomMetrics[i].Name)
+		}
+		if err != nil {
+			logging.Logger().Error("Error parsing config file", "file", configFile, "entry", fmt.Sprintf("custom_metrics[%d]", i), "err", err)
+			return fmt.Errorf("custom_metrics[%d], %v", i, err)
+		}
+		customMetricNames[c.CustomMetrics[i].Name] = true
+	}
+	if c.SeriesLimits.PerTarget < 0 {
+		logging.Logger().Error("Error parsing config file, negative per_target", "file", configFile, "entry", "series_limits")
+		return fmt.Errorf("series_limits, per_target must not be negative")
+	}
+	for collector, limit := range c.SeriesLimits.Collectors {
+		if limit < 0 {
+			logging.Logger().Error("Error parsing config file, negative collector limit", "file", configFile, "entry", "series_limits", "collector", collector)
+			return fmt.Errorf("series_limits, limit of collector %s must not be negative", collector)
+		}
+	}
+	if len(c.Callers) > 0 && !sc.BasicAuthUsers {
+		logging.Logger().Error("Error parsing config file, callers are set without basic auth users", "file", configFile, "entry", "callers")
+		return fmt.Errorf("callers, basic_auth_users must be set in the web config file to authenticate the callers")
+	}
+	entryFilters := map[string]map[string]*FilterConfig{"filters": c.Filters}
+	for target, clusterConfig := range c.Clusters {
+		entryFilters[fmt.Sprintf("clusters[%s].filters", target)] = clusterConfig.Filters
+	}
+	for i, rule := range c.Credentials {
+		entryFilters[fmt.Sprintf("credentials[%d].filters", i)] = rule.Filters
+	}
+	for entry, filters := range entryFilters {
+		if err := compileFilters(filters); err != nil {
+			logging.Logger().Error("Error parsing config file", "file", configFile, "entry", entry, "err", err)
+			return fmt.Errorf("%s%v", entry, err)
+		}
+	}
 
 	sc.Lock()
 	sc.C = c
 	sc.Unlock()
 
-	log.Infoln("Loaded config file")
+	logging.Logger().Info("Loaded config file", "file", configFile)
 	return nil
 }
 func (sc *SafeConfig) SetSingleModeClusterCredential() (*ClusterConfig, error) {
@@ -50,19 +451,113 @@ func (sc *SafeConfig) SetSingleModeClusterCredential() (*ClusterConfig, error) {
 
 }
 func (sc *SafeConfig) ClusterConfigForTarget(target string) (*ClusterConfig, error) {
-	sc.Lock()
-	defer sc.Unlock()
+	clusterConfig, _, err := sc.ResolveTarget(target)
+	return clusterConfig, err
+}
+
+// ResolveTarget returns the cluster config of the target and describes where it comes from, e.g. "clusters[vc01]" or "credentials[1], cidr 10.36.0.0/16".
+// A target is resolved by its clusters entry, then by the first matching credential rule, then by the default credentials.
+func (sc *SafeConfig) ResolveTarget(target string) (*ClusterConfig, string, error) {
+	sc.RLock()
+	defer sc.RUnlock()
 	if clusterConfig, ok := sc.C.Clusters[target]; ok {
-		return &ClusterConfig{
-			Username: clusterConfig.Username,
-			Password: clusterConfig.Password,
-		}, nil
+		clusterConfig.Filters = sc.C.filtersOf(clusterConfig)
+		return &clusterConfig, fmt.Sprintf("clusters[%s]", target), nil
+	}
+	for i, rule := range sc.C.Credentials {
+		if rule.matches(target) {
+			clusterConfig := rule.ClusterConfig
+			// a rule applies to many targets, so it can't override their address
+			clusterConfig.URL = ""
+			clusterConfig.Filters = sc.C.filtersOf(clusterConfig)
+			return &clusterConfig, fmt.Sprintf("credentials[%d], %s", i, rule.String()), nil
+		}
+	}
+	if sc.C.ListedTargetsOnly {
+		return nil, "", ErrTargetNotListed
 	}
 	if clusterConfig, ok := sc.C.Clusters["default"]; ok {
-		return &ClusterConfig{
-			Username: clusterConfig.Username,
-			Password: clusterConfig.Password,
-		}, nil
+		// the address of a target can't be overridden by the default credentials
+		clusterConfig.URL = ""
+		clusterConfig.Filters = sc.C.filtersOf(clusterConfig)
+		return &clusterConfig, "clusters[default]", nil
+	}
+	return nil, "", fmt.Errorf("no credentials found for target %s", target)
+}
+
+// ClusterConfigs returns the config of every configured target, the default credentials are not a target
+func (sc *SafeConfig) ClusterConfigs() map[string]*ClusterConfig {
+	sc.RLock()
+	defer sc.RUnlock()
+	clusterConfigs := map[string]*ClusterConfig{}
+	for target, clusterConfig := range sc.C.Clusters {
+		if target == "default" {
+			continue
+		}
+		clusterConfig := clusterConfig
+		clusterConfig.Filters = sc.C.filtersOf(clusterConfig)
+		clusterConfigs[target] = &clusterConfig
+	}
+	return clusterConfigs
+}
+
+// filtersOf returns the filters a target is scraped with, those of its cluster config override those of the config by object type
+func (c *Config) filtersOf(clusterConfig ClusterConfig) map[string]*FilterConfig {
+	if len(clusterConfig.Filters) == 0 {
+		return c.Filters
+	}
+	filters := map[string]*FilterConfig{}
+	for objectType, filter := range c.Filters {
+		filters[objectType] = filter
 	}
-	return nil, fmt.Errorf("no credentials found for target %s", target)
+	for objectType, filter := range clusterConfig.Filters {
+		filters[objectType] = filter
+	}
+	return filters
+}
+
+// CallerAllowed reports whether the caller, i.e. the basic auth user, can scrape the target, which resolves to the module.
+// The caller is allowed if the target or the config entry of its module, e.g. "credentials[1]" of "credentials[1], cidr 10.36.0.0/16", is listed.
+// Every caller is allowed without callers, otherwise an unlisted caller is restricted by the "default" entry, or denied without one.
+func (sc *SafeConfig) CallerAllowed(caller string, target string, module string) bool {
+	sc.RLock()
+	defer sc.RUnlock()
+	if len(sc.C.Callers) == 0 {
+		return true
+	}
+	callerConfig, ok := sc.C.Callers[caller]
+	if !ok {
+		if callerConfig, ok = sc.C.Callers["default"]; !ok {
+			return false
+		}
+	}
+	for _, allowed := range callerConfig.Targets {
+		if allowed == target {
+			return true
+		}
+	}
+	if module == "" {
+		return false
+	}
+	entry := strings.SplitN(module, ",", 2)[0]
+	for _, allowed := range callerConfig.Modules {
+		if allowed == module || allowed == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// CustomMetrics returns the custom metrics of the config
+func (sc *SafeConfig) CustomMetrics() []CustomMetric {
+	sc.RLock()
+	defer sc.RUnlock()
+	return sc.C.CustomMetrics
+}
+
+// SeriesLimits returns the series limits of the config
+func (sc *SafeConfig) SeriesLimits() SeriesLimits {
+	sc.RLock()
+	defer sc.RUnlock()
+	return sc.C.SeriesLimits
 }
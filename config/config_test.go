@@ -0,0 +1,271 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestListedTargetsOnly(t *testing.T) {
+	sc := &SafeConfig{C: &Config{
+		Clusters: map[string]ClusterConfig{
+			"default": {Username: "default", Password: "pass"},
+			"vc01":    {Username: "user", Password: "pass"},
+		},
+	}}
+
+	if clusterConfig, err := sc.ClusterConfigForTarget("vc02"); err != nil || clusterConfig.Username != "default" {
+		t.Errorf("expected the default credentials for an unlisted target, got %v, %v", clusterConfig, err)
+	}
+
+	sc.C.ListedTargetsOnly = true
+	if clusterConfig, err := sc.ClusterConfigForTarget("vc01"); err != nil || clusterConfig.Username != "user" {
+		t.Errorf("expected the credentials of a listed target, got %v, %v", clusterConfig, err)
+	}
+	if _, err := sc.ClusterConfigForTarget("vc02"); err != ErrTargetNotListed {
+		t.Errorf("expected an unlisted target to be rejected, got %v", err)
+	}
+}
+
+func TestCallerAllowed(t *testing.T) {
+	sc := &SafeConfig{C: &Config{
+		Callers: map[string]CallerConfig{
+			"team-a": {Targets: []string{"vc01", "all"}},
+			"team-b": {Targets: []string{"vc02"}},
+			"team-c": {Modules: []string{"credentials[1]", "clusters[vc03]"}},
+		},
+	}}
+
+	tests := []struct {
+		caller  string
+		target  string
+		module  string
+		allowed bool
+	}{
+		{"team-a", "vc01", "clusters[vc01]", true},
+		{"team-a", "all", "", true},
+		{"team-a", "vc02", "clusters[vc02]", false},
+		{"team-b", "vc02", "clusters[vc02]", true},
+		{"team-b", "all", "", false},
+		{"team-c", "10.36.51.11", "credentials[1], cidr 10.36.0.0/16", true},
+		{"team-c", "vc03", "clusters[vc03]", true},
+		{"team-c", "10.37.51.11", "credentials[2], cidr 10.37.0.0/16", false},
+		{"team-c", "vc04", "clusters[default]", false},
+		{"team-c", "vc05", "", false},
+		// unlisted callers are denied
+		{"admin", "vc02", "clusters[vc02]", false},
+		{"", "vc01", "clusters[vc01]", false},
+	}
+	for _, test := range tests {
+		if allowed := sc.CallerAllowed(test.caller, test.target, test.module); allowed != test.allowed {
+			t.Errorf("caller %q, target %s, module %q: expected %v, got %v", test.caller, test.target, test.module, test.allowed, allowed)
+		}
+	}
+
+	// unlisted callers get the default entry
+	sc.C.Callers["default"] = CallerConfig{Targets: []string{"vc01"}}
+	if !sc.CallerAllowed("admin", "vc01", "clusters[vc01]") || sc.CallerAllowed("admin", "vc02", "clusters[vc02]") {
+		t.Errorf("expected an unlisted caller to be restricted by the default entry")
+	}
+	// every caller is allowed without callers
+	sc.C.Callers = nil
+	if !sc.CallerAllowed("admin", "vc02", "clusters[vc02]") {
+		t.Errorf("expected every caller to be allowed without callers")
+	}
+}
+
+func TestCredentialRules(t *testing.T) {
+	sc := &SafeConfig{C: &Config{
+		Clusters: map[string]ClusterConfig{
+			"default":            {Username: "default"},
+			"esx-01.example.com": {Username: "listed"},
+		},
+		Credentials: []CredentialRule{
+			{Glob: "esx-*.example.com", ClusterConfig: ClusterConfig{Username: "glob", URL: "https://10.0.0.1/sdk"}},
+			{Regex: `esx[0-9]+\.site2\.example\.com(:[0-9]+)?`, ClusterConfig: ClusterConfig{Username: "regex"}},
+			{CIDR: "10.36.0.0/16", ClusterConfig: ClusterConfig{Username: "cidr"}},
+			{CIDR: "fd00::/8", ClusterConfig: ClusterConfig{Username: "cidr6"}},
+			{Glob: "*", ClusterConfig: ClusterConfig{Username: "catch-all"}},
+		},
+	}}
+	for i := range sc.C.Credentials {
+		if err := sc.C.Credentials[i].compile(); err != nil {
+			t.Fatalf("Error when compiling credentials[%d], %v", i, err)
+		}
+	}
+
+	tests := []struct {
+		target   string
+		username string
+		source   string
+	}{
+		{"esx-01.example.com", "listed", "clusters[esx-01.example.com]"},
+		{"esx-02.example.com", "glob", "credentials[0], glob esx-*.example.com"},
+		{"esx12.site2.example.com:443", "regex", `credentials[1], regex esx[0-9]+\.site2\.example\.com(:[0-9]+)?`},
+		{"xesx12.site2.example.com", "catch-all", "credentials[4], glob *"},
+		{"10.36.51.11", "cidr", "credentials[2], cidr 10.36.0.0/16"},
+		{"10.36.51.11:8443", "cidr", "credentials[2], cidr 10.36.0.0/16"},
+		{"https://10.36.51.11/sdk", "cidr", "credentials[2], cidr 10.36.0.0/16"},
+		{"[fd00::1]:443", "cidr6", "credentials[3], cidr fd00::/8"},
+		{"fd00::1", "cidr6", "credentials[3], cidr fd00::/8"},
+		{"https://vc01.example.com/sdk", "default", "clusters[default]"},
+	}
+	for _, test := range tests {
+		clusterConfig, source, err := sc.ResolveTarget(test.target)
+		if err != nil {
+			t.Errorf("target %s: unexpected error %v", test.target, err)
+			continue
+		}
+		if clusterConfig.Username != test.username || source != test.source {
+			t.Errorf("target %s: expected %s from %s, got %s from %s", test.target, test.username, test.source, clusterConfig.Username, source)
+		}
+		if clusterConfig.URL != "" {
+			t.Errorf("target %s: the address can't be overridden by a rule, got %s", test.target, clusterConfig.URL)
+		}
+	}
+}
+
+func TestInvalidCredentialRules(t *testing.T) {
+	rules := []CredentialRule{
+		{},
+		{Glob: "esx-*", CIDR: "10.0.0.0/8"},
+		{Glob: "esx-["},
+		{Regex: "esx("},
+		{CIDR: "10.0.0.1"},
+	}
+	for _, rule := range rules {
+		if err := rule.compile(); err == nil {
+			t.Errorf("expected rule %+v to be invalid", rule)
+		}
+	}
+}
+
+func TestInvalidCustomMetrics(t *testing.T) {
+	builtinMetricNames := []string{"vsphere_up", "vsphere_host_uptime"}
+	valid := CustomMetric{Name: "host_cpu_mhz", Object: "HostSystem", Property: "summary.hardware.cpuMhz"}
+	if err := valid.validate(builtinMetricNames); err != nil {
+		t.Fatalf("expected metric %+v to be valid, %v", valid, err)
+	}
+	metrics := []CustomMetric{
+		{Name: "host-cpu", Object: "HostSystem", Property: "summary.hardware.cpuMhz"},
+		{Name: "host_cpu_mhz", Type: "histogram", Object: "HostSystem", Property: "summary.hardware.cpuMhz"},
+		{Name: "host_cpu_mhz", Object: "Host", Property: "summary.hardware.cpuMhz"},
+		{Name: "host_cpu_mhz", Object: "HostSystem"},
+		{Name: "host_cpu_mhz", Object: "HostSystem", Property: "summary.hardware.cpuMhz", Labels: []CustomLabel{{Name: "name", Property: "summary.hardware.vendor"}}},
+		{Name: "host_cpu_mhz", Object: "HostSystem", Property: "summary.hardware.cpuMhz", Labels: []CustomLabel{{Name: "cpu:model", Property: "summary.hardware.cpuModel"}}},
+		{Name: "host_cpu_mhz", Object: "HostSystem", Property: "summary.hardware.cpuMhz", Labels: []CustomLabel{{Name: "vendor"}}},
+		{Name: "host_uptime", Object: "HostSystem", Property: "summary.quickStats.uptime"},
+		{Name: "up", Object: "HostSystem", Property: "runtime.powerState"},
+		{Name: "exporter_scrapes_in_flight", Object: "HostSystem", Property: "summary.hardware.cpuMhz"},
+	}
+	for _, metric := range metrics {
+		if err := metric.validate(builtinMetricNames); err == nil {
+			t.Errorf("expected metric %+v to be invalid", metric)
+		}
+	}
+}
+
+func TestFilterKeep(t *testing.T) {
+	filter := &FilterConfig{
+		Include: &FilterRules{Names: []string{"srv-.*"}, Tags: []string{"env/prod", "backup"}},
+		Exclude: &FilterRules{PowerStates: []string{"poweredOff"}},
+	}
+	for _, rules := range []*FilterRules{filter.Include, filter.Exclude} {
+		if err := rules.compile("vm"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		object FilterObject
+		keep   bool
+	}{
+		{FilterObject{Name: "srv-01", Tags: []string{"env/prod"}, PowerState: "poweredOn"}, true},
+		{FilterObject{Name: "srv-02", Tags: []string{"policy/backup"}, PowerState: "poweredOn"}, true},
+		{FilterObject{Name: "srv-03", Tags: []string{"env/test"}, PowerState: "poweredOn"}, false},
+		{FilterObject{Name: "vdi-01", Tags: []string{"env/prod"}, PowerState: "poweredOn"}, false},
+		{FilterObject{Name: "srv-04", Tags: []string{"env/prod"}, PowerState: "poweredOff"}, false},
+		{FilterObject{Name: "srv-05", Tags: []string{"env/prod"}, PowerState: "poweredOn", Template: true}, false},
+	}
+	for _, test := range tests {
+		if keep := filter.Keep(test.object); keep != test.keep {
+			t.Errorf("expected keep of %+v to be %v", test.object, test.keep)
+		}
+	}
+
+	var noFilter *FilterConfig
+	if !noFilter.Keep(FilterObject{Name: "srv-01"}) || noFilter.Keep(FilterObject{Name: "tpl-01", Template: true}) {
+		t.Errorf("expected only templates to be excluded without filter")
+	}
+}
+
+func TestInvalidFilterRules(t *testing.T) {
+	tests := []struct {
+		objectType string
+		rules      FilterRules
+	}{
+		{"vm", FilterRules{Names: []string{"srv-("}}},
+		{"host", FilterRules{ResourcePools: []string{"Resources"}}},
+		{"datastore", FilterRules{PowerStates: []string{"poweredOn"}}},
+	}
+	for _, test := range tests {
+		if err := test.rules.compile(test.objectType); err == nil {
+			t.Errorf("expected rules %+v of %s to be invalid", test.rules, test.objectType)
+		}
+	}
+}
+
+func TestResolveTargetFilters(t *testing.T) {
+	vmFilter := &FilterConfig{Templates: true}
+	hostFilter := &FilterConfig{Exclude: &FilterRules{PowerStates: []string{"poweredOff"}}}
+	ruleFilter := &FilterConfig{}
+	sc := &SafeConfig{C: &Config{
+		Clusters: map[string]ClusterConfig{
+			"default": {Username: "default"},
+			"vc01":    {Username: "listed", Filters: map[string]*FilterConfig{"vm": nil}},
+		},
+		Credentials: []CredentialRule{
+			{Glob: "esx-*", ClusterConfig: ClusterConfig{Username: "glob", Filters: map[string]*FilterConfig{"host": ruleFilter}}},
+		},
+		Filters: map[string]*FilterConfig{"vm": vmFilter, "host": hostFilter},
+	}}
+
+	tests := []struct {
+		target string
+		vm     *FilterConfig
+		host   *FilterConfig
+	}{
+		{"vc01", nil, hostFilter},
+		{"esx-01", vmFilter, ruleFilter},
+		{"vc02", vmFilter, hostFilter},
+	}
+	for _, test := range tests {
+		clusterConfig, _, err := sc.ResolveTarget(test.target)
+		if err != nil {
+			t.Fatalf("Error when resolving %s, %v", test.target, err)
+		}
+		if clusterConfig.Filters["vm"] != test.vm || clusterConfig.Filters["host"] != test.host {
+			t.Errorf("target %s: expected the vm filter %p and host filter %p, got %v", test.target, test.vm, test.host, clusterConfig.Filters)
+		}
+	}
+	if clusterConfig := sc.ClusterConfigs()["vc01"]; clusterConfig.Filters["vm"] != nil || clusterConfig.Filters["host"] != hostFilter {
+		t.Errorf("expected the filters of vc01 to override the vm filter only, got %v", clusterConfig.Filters)
+	}
+	if sc.C.Clusters["vc01"].Filters["host"] != nil {
+		t.Errorf("expected the filters of the config to be left alone")
+	}
+}
+
+func TestCallersNeedBasicAuth(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yml")
+	if err := ioutil.WriteFile(configFile, []byte("callers:\n  team-a:\n    targets: [vc01]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sc := &SafeConfig{C: &Config{}}
+	if err := sc.ReloadConfig(configFile); err == nil {
+		t.Errorf("expected callers without basic auth users to be rejected")
+	}
+	sc.BasicAuthUsers = true
+	if err := sc.ReloadConfig(configFile); err != nil {
+		t.Errorf("expected callers with basic auth users to load, got %v", err)
+	}
+}
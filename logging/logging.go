@@ -0,0 +1,125 @@
+// Package logging sets up the structured, leveled logs of the exporter, written to stderr as logfmt or JSON.
+// The logs of a scrape carry the target, the config entry it resolved to as module, the collector and the scrape ID.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/prometheus/common/promlog"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	level = kingpin.Flag(
+		"log.level",
+		"Only log messages with the given severity or above, one of debug, info, warn or error. The targets with debug set in the config file are logged at debug level.",
+	).Default("info").Enum("debug", "info", "warn", "error")
+	format = kingpin.Flag(
+		"log.format",
+		"Output format of the log messages, one of logfmt or json.",
+	).Default("logfmt").Enum("logfmt", "json")
+
+	logger atomic.Pointer[slog.Logger]
+	// scrapeID numbers the collections of the targets since the exporter started
+	scrapeID atomic.Uint64
+)
+
+// Attribute keys of the log messages
+const (
+	TargetKey    = "target"
+	ModuleKey    = "module"
+	CollectorKey = "collector"
+	ScrapeIDKey  = "scrape_id"
+)
+
+func init() {
+	// the messages are logged at info level until Setup applies the flags, which tests don't parse
+	logger.Store(newLogger(os.Stderr, "logfmt", slog.LevelInfo))
+}
+
+// Setup applies --log.level and --log.format, it is called once the flags are parsed
+func Setup() {
+	var minLevel slog.Level
+	// the levels of the flag are all known to slog
+	minLevel.UnmarshalText([]byte(*level))
+	logger.Store(newLogger(os.Stderr, *format, minLevel))
+}
+
+func newLogger(w io.Writer, format string, minLevel slog.Level) *slog.Logger {
+	// the handler writes every message, levelHandler decides which are enabled, so a logger can be switched to debug level
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var handler slog.Handler = slog.NewTextHandler(w, options)
+	if format == "json" {
+		handler = slog.NewJSONHandler(w, options)
+	}
+	return slog.New(&levelHandler{handler: handler, level: minLevel})
+}
+
+// Logger returns the logger of the exporter
+func Logger() *slog.Logger {
+	return logger.Load()
+}
+
+// ToolkitLogger returns a logger of the exporter toolkit, which logs through go-kit, with the same level and format
+func ToolkitLogger() kitlog.Logger {
+	config := &promlog.Config{Level: &promlog.AllowedLevel{}, Format: &promlog.AllowedFormat{}}
+	config.Level.Set(*level)
+	config.Format.Set(*format)
+	return promlog.New(config)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the logger
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger of the context, the logger of the exporter if it carries none
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return Logger()
+}
+
+// WithDebug returns the logger logging at debug level whatever --log.level
+func WithDebug(logger *slog.Logger) *slog.Logger {
+	if handler, ok := logger.Handler().(*levelHandler); ok {
+		return slog.New(&levelHandler{handler: handler.handler, level: slog.LevelDebug})
+	}
+	return logger
+}
+
+// NewScrapeID returns the ID of a new collection of a target
+func NewScrapeID() string {
+	return strconv.FormatUint(scrapeID.Add(1), 10)
+}
+
+// levelHandler enables the messages of its level and above, the loggers derived from it keep its level
+type levelHandler struct {
+	handler slog.Handler
+	level   slog.Level
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *levelHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler.Handle(ctx, record)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{handler: h.handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{handler: h.handler.WithGroup(name), level: h.level}
+}
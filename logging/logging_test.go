@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestDebugTarget(t *testing.T) {
+	var out bytes.Buffer
+	logger := newLogger(&out, "logfmt", slog.LevelInfo).With(ModuleKey, "clusters[vc01]")
+
+	logger.With(TargetKey, "vc01").Debug("retrieved objects")
+	if out.Len() != 0 {
+		t.Fatalf("Expected no debug message at info level, got %q", out.String())
+	}
+	WithDebug(logger).With(TargetKey, "vc01", CollectorKey, "host").Debug("retrieved objects")
+	if line := out.String(); !strings.Contains(line, "level=DEBUG") || !strings.Contains(line, "module=clusters[vc01] target=vc01 collector=host") {
+		t.Errorf("Expected the debug message of the target with its fields, got %q", line)
+	}
+
+	out.Reset()
+	logger.Debug("retrieved objects")
+	if out.Len() != 0 {
+		t.Errorf("Expected the logger of the other targets to stay at info level, got %q", out.String())
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	var out bytes.Buffer
+	logger := newLogger(&out, "json", slog.LevelWarn)
+	ctx := NewContext(context.Background(), logger.With(TargetKey, "vc01", ScrapeIDKey, "7"))
+
+	FromContext(ctx).Info("starting scraping target")
+	FromContext(ctx).Error("Errors occour when retrieving the inventory", "err", "timeout")
+	var message map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &message); err != nil {
+		t.Fatalf("Expected a single JSON message, %v, got %q", err, out.String())
+	}
+	if message["level"] != "ERROR" || message[TargetKey] != "vc01" || message[ScrapeIDKey] != "7" || message["err"] != "timeout" {
+		t.Errorf("Unexpected message %v", message)
+	}
+	if FromContext(context.Background()) != Logger() {
+		t.Errorf("Expected the logger of the exporter for a context carrying none")
+	}
+}
@@ -2,12 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"fmt"
 	"github.com/jenningsloy318/vsphere_exporter/collector"
 	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/jenningsloy318/vsphere_exporter/logging"
+	"github.com/jenningsloy318/vsphere_exporter/tracing"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/prometheus/common/log"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/exporter-toolkit/web"
+	"github.com/prometheus/exporter-toolkit/web/kingpinflag"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
@@ -15,6 +23,7 @@ import (
 )
 
 var (
+	webConfig  = kingpinflag.AddFlags(kingpin.CommandLine)
 	configFile = kingpin.Flag(
 		"config.file",
 		"Path to configuration file.",
@@ -23,69 +32,294 @@ var (
 		"web.listen-address",
 		"Address to listen on for web interface and telemetry.",
 	).Default(":9272").String()
-	sc = &config.SafeConfig{
-		C: &config.Config{},
+	aggregateConcurrency = kingpin.Flag(
+		"aggregate.concurrency",
+		"Maximum number of targets scraped concurrently by /vsphere/all.",
+	).Default("4").Int()
+	scrapeConcurrency = kingpin.Flag(
+		"scrape.max-concurrency",
+		"Maximum number of targets collected at once, concurrent scrapes of the same target share one collection, 0 means unlimited.",
+	).Default("8").Int()
+	scrapeQueue = kingpin.Flag(
+		"scrape.max-queue",
+		"Maximum number of collections waiting for a free slot, further scrapes are rejected with 503.",
+	).Default("16").Int()
+	pollInterval = kingpin.Flag(
+		"poll.interval",
+		"Interval the clusters are collected at in poll mode, unless their poll_interval is set.",
+	).Default("5m").Duration()
+	scrapeLimiter *collector.ScrapeLimiter
+	// poller collects the clusters in the background in poll mode
+	poller *collector.Poller
+	sc     = &config.SafeConfig{
+		C:                  &config.Config{},
+		BuiltinMetricNames: collector.BuiltinMetricNames(),
 	}
 	reloadCh chan chan error
+
+	targetRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "vsphere",
+			Subsystem: "exporter",
+			Name:      "target_rejections_total",
+			Help:      "Number of scrapes rejected by the access control, by reason.",
+		},
+		[]string{"reason"},
+	)
 )
 
+// checkCaller rejects the request if its caller isn't allowed to scrape the target or the module it resolves to
+func checkCaller(w http.ResponseWriter, r *http.Request, target string) bool {
+	caller, _, _ := r.BasicAuth()
+	var module string
+	if target != aggregateTarget {
+		// a target which can't be resolved has no module, it is only allowed if listed
+		_, module, _ = sc.ResolveTarget(target)
+	}
+	if !sc.CallerAllowed(caller, target, module) {
+		logging.Logger().Error("Rejected scraping target, the target isn't allowed for the caller", logging.TargetKey, target, "caller", caller)
+		targetRejections.WithLabelValues("caller_not_allowed").Inc()
+		http.Error(w, fmt.Sprintf("target %s is not allowed", target), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// rejectUnlisted rejects the request if the target couldn't be resolved as it isn't listed in the config
+func rejectUnlisted(w http.ResponseWriter, target string, err error) bool {
+	if err != config.ErrTargetNotListed {
+		return false
+	}
+	logging.Logger().Error("Rejected scraping target", logging.TargetKey, target, "err", err)
+	targetRejections.WithLabelValues("target_not_listed").Inc()
+	http.Error(w, fmt.Sprintf("target %s is not allowed", target), http.StatusForbidden)
+	return true
+}
+
 // define new http handleer
 func metricsHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		registry := prometheus.NewRegistry()
-		var target string
-		var ctx context.Context
+		var target, module string
 		var clusterConfig *config.ClusterConfig
 		var err error
 		if sc.C.Mode == "single" {
 			target = sc.C.EnabledCluster
-			if clusterConfig, err = sc.SetSingleModeClusterCredential(); err != nil {
-				log.Errorf("Error getting credential %s", target, err)
+			if !checkCaller(w, r, target) {
+				return
+			}
+			if clusterConfig, module, err = sc.ResolveTarget(target); err != nil {
+				logging.Logger().Error("Error getting credential for target", logging.TargetKey, target, "err", err)
 				return
 			}
 		} else {
 			target = r.URL.Query().Get("target")
+			if target == aggregateTarget {
+				aggregateHandler().ServeHTTP(w, r)
+				return
+			}
 			if target == "" {
 				http.Error(w, "'target' parameter must be specified in multi scrape mode", 400)
 				return
 			}
+			if !checkCaller(w, r, target) {
+				return
+			}
+			if sc.C.Mode == "poll" {
+				pollHandler(w, r, target)
+				return
+			}
 
-			if clusterConfig, err = sc.ClusterConfigForTarget(target); err != nil {
-				log.Errorf("Error getting credential for target %s,%s", target, err)
+			if clusterConfig, module, err = sc.ResolveTarget(target); rejectUnlisted(w, target, err) {
 				return
+			} else if err != nil {
+				logging.Logger().Error("Error getting credential for target", logging.TargetKey, target, "err", err)
+				return
+			}
+		}
+		serveLimited(w, r, target, scrapeKey("vsphere", target, module, clusterConfig), func() ([]*dto.MetricFamily, error) {
+			logging.Logger().Info("starting scraping target", logging.TargetKey, target, logging.ModuleKey, module)
+			registry := prometheus.NewRegistry()
+			// the collection may be shared with other scrapes, so it isn't canceled with this request, it is only traced as part of it
+			ctx := logging.NewContext(context.WithoutCancel(r.Context()), logging.Logger().With(logging.ModuleKey, module))
+			vsphereCollector := collector.NewVshpereCollector(ctx, target, clusterConfig)
+			// a custom metric clashing with a built-in metric fails the registration
+			if err := registry.Register(vsphereCollector); err != nil {
+				return nil, err
 			}
-			ctx = r.Context()
+			return registry.Gather()
+		}, promhttp.HandlerOpts{})
+	}
+}
+
+// pollHandler serves the last complete collection of a target polled in the background
+func pollHandler(w http.ResponseWriter, r *http.Request, target string) {
+	gatherer, ok := poller.Gatherer(target)
+	if !ok {
+		logging.Logger().Error("Rejected scraping target, it isn't polled", logging.TargetKey, target)
+		http.Error(w, fmt.Sprintf("target %s is not polled, only the clusters of the config are", target), http.StatusNotFound)
+		return
+	}
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, gatherer}
+	promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
 
+// tracedHandler traces the requests as server spans, continuing the trace context they carry
+func tracedHandler(name string, handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.StartRequest(r, name)
+		defer span.End()
+		if target := r.URL.Query().Get("target"); target != "" {
+			span.SetAttributes(tracing.TargetKey.String(target))
+		}
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// credentialsHandler shows which cluster config a target resolves to, without its password
+func credentialsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "'target' parameter must be specified", 400)
+			return
+		}
+		if !checkCaller(w, r, target) {
+			return
 		}
-		if ctx == nil {
-			ctx = context.Background()
+		clusterConfig, source, err := sc.ResolveTarget(target)
+		if rejectUnlisted(w, target, err) {
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("target %s: %s", target, err), http.StatusNotFound)
+			return
 		}
-		log.Infof("starting scraping target %s", target)
-		collector := collector.NewVshpereCollector(ctx, target, clusterConfig.Username, clusterConfig.Password)
-		registry.MustRegister(collector)
-		gatherers := prometheus.Gatherers{
-			prometheus.DefaultGatherer,
-			registry,
+		fmt.Fprintf(w, "target: %s\nsource: %s\nusername: %s\n", target, source, clusterConfig.Username)
+	}
+}
+
+// aggregateTarget is the target scraping every configured cluster
+const aggregateTarget = "all"
+
+// aggregateHandler scrapes every configured cluster, each series is labelled with its vcenter
+func aggregateHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkCaller(w, r, aggregateTarget) {
+			return
+		}
+		if sc.C.Mode == "poll" {
+			gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, prometheus.GathererFunc(poller.GatherAll)}
+			promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}).ServeHTTP(w, r)
+			return
 		}
-		// Delegate http serving to Prometheus client library, which will call collector.Collect.
-		h := promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{})
-		h.ServeHTTP(w, r)
+		serveLimited(w, r, aggregateTarget, scrapeKey("aggregate", aggregateTarget, "", nil), func() ([]*dto.MetricFamily, error) {
+			clusterConfigs := sc.ClusterConfigs()
+			logging.Logger().Info("starting scraping targets", "targets", len(clusterConfigs))
+			return collector.NewAggregateGatherer(context.WithoutCancel(r.Context()), clusterConfigs, *aggregateConcurrency).Gather()
+		}, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError})
+	}
+}
 
+// serveLimited collects the target through the scrape limiter, sharing the collections of the same key, and serves the result along with the exporter metrics
+func serveLimited(w http.ResponseWriter, r *http.Request, target string, key string, collect func() ([]*dto.MetricFamily, error), opts promhttp.HandlerOpts) {
+	metricFamilies, err := scrapeLimiter.Gather(target, key, collect)
+	if err == collector.ErrScrapeQueueFull {
+		logging.Logger().Error("Rejected scraping target", logging.TargetKey, target, "err", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
 	}
+	gatherers := prometheus.Gatherers{
+		prometheus.DefaultGatherer,
+		prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return metricFamilies, err
+		}),
+	}
+	// Delegate http serving to Prometheus client library
+	h := promhttp.HandlerFor(gatherers, opts)
+	h.ServeHTTP(w, r)
+}
+
+// scrapeKey identifies the collections the scrapes can share, those of the same handler, target, module and resolved config
+func scrapeKey(handler string, target string, module string, clusterConfig *config.ClusterConfig) string {
+	// the config is hashed so the key doesn't hold the password
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%#v", clusterConfig)))
+	return fmt.Sprintf("%s\xff%s\xff%s\xff%x", handler, target, module, hash)
+}
+
+// loadConfig loads the config file and applies its custom metrics and series limits, the filters are resolved with the targets
+func loadConfig() error {
+	if err := sc.ReloadConfig(*configFile); err != nil {
+		return err
+	}
+	collector.SetCustomMetrics(sc.CustomMetrics())
+	collector.SetSeriesLimits(sc.SeriesLimits())
+	return nil
+}
+
+// basicAuthUsers tells whether basic auth users are set in the web config file, which authenticate the callers
+func basicAuthUsers(webConfigFile string) (bool, error) {
+	if webConfigFile == "" {
+		return false, nil
+	}
+	content, err := ioutil.ReadFile(webConfigFile)
+	if err != nil {
+		return false, err
+	}
+	var webConfig web.Config
+	if err := yaml.Unmarshal(content, &webConfig); err != nil {
+		return false, err
+	}
+	return len(webConfig.Users) > 0, nil
+}
+
+// reloadConfig loads the config file and applies it along with its polled clusters
+func reloadConfig() error {
+	if err := loadConfig(); err != nil {
+		return err
+	}
+	// the clusters are polled from the first load in poll mode, and no longer polled once switched to another mode
+	if sc.C.Mode == "poll" {
+		poller.SetTargets(sc.ClusterConfigs())
+	} else {
+		poller.SetTargets(nil)
+	}
+	return nil
 }
 
 func main() {
-	log.AddFlags(kingpin.CommandLine)
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
-	log.Infoln("Starting vsphere_exporter")
+	command := kingpin.Parse()
+	logging.Setup()
+	logger := logging.Logger()
+	if command != serveCommand.FullCommand() {
+		if err := runCommand(command); err != nil {
+			logger.Error("Error running command", "command", command, "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+	logger.Info("Starting vsphere_exporter")
+	scrapeLimiter = collector.NewScrapeLimiter(*scrapeConcurrency, *scrapeQueue)
+	prometheus.MustRegister(targetRejections)
+	poller = collector.NewPoller(context.Background(), *pollInterval)
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		logger.Error("Error setting up tracing", "err", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+	// the callers of the config are only restricted along with basic auth
+	if sc.BasicAuthUsers, err = basicAuthUsers(*webConfig); err != nil {
+		logger.Error("Error parsing web config file", "file", *webConfig, "err", err)
+		os.Exit(1)
+	}
 	// load config  first time
-	if err := sc.ReloadConfig(*configFile); err != nil {
-		log.Fatalf("Error parsing config file: %s", err)
+	if err := reloadConfig(); err != nil {
+		logger.Error("Error parsing config file", "err", err)
+		os.Exit(1)
 	}
 
 	// load config in background to wathc config changes
-	hup := make(chan os.Signal)
+	hup := make(chan os.Signal, 1)
 	reloadCh = make(chan chan error)
 	signal.Notify(hup, syscall.SIGHUP)
 
@@ -93,12 +327,12 @@ func main() {
 		for {
 			select {
 			case <-hup:
-				if err := sc.ReloadConfig(*configFile); err != nil {
-					log.Errorf("Error reloading config: %s", err)
+				if err := reloadConfig(); err != nil {
+					logger.Error("Error reloading config", "err", err)
 				}
 			case rc := <-reloadCh:
-				if err := sc.ReloadConfig(*configFile); err != nil {
-					log.Errorf("Error reloading config: %s", err)
+				if err := reloadConfig(); err != nil {
+					logger.Error("Error reloading config", "err", err)
 					rc <- err
 				} else {
 					rc <- nil
@@ -107,8 +341,11 @@ func main() {
 		}
 	}()
 
-	http.Handle("/vsphere", metricsHandler()) // Regular metrics endpoint for local vsphere metrics.
+	http.Handle("/vsphere", tracedHandler("scrape", metricsHandler())) // Regular metrics endpoint for local vsphere metrics.
+	http.Handle("/vsphere/all", tracedHandler("scrape all", aggregateHandler()))
 	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/debug/credentials", credentialsHandler())
+	http.Handle("/collectors", collector.CollectorsHandler())
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
@@ -122,13 +359,17 @@ func main() {
             <input type="submit" value="Submit">
 			</form>
 			<p><a href="/metrics">Local metrics</a></p>
+			<p><a href="/collectors">Collectors</a></p>
             </body>
             </html>`))
 	})
 
-	log.Infof("Listening on %s", *listenAddress)
-	err := http.ListenAndServe(*listenAddress, nil)
+	logger.Info("Listening", "address", *listenAddress)
+	// TLS and basic auth of all endpoints are set in the web config file
+	server := &http.Server{Addr: *listenAddress}
+	err = web.ListenAndServe(server, *webConfig, logging.ToolkitLogger())
 	if err != nil {
-		log.Fatal(err)
+		logger.Error("Error serving", "err", err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/jenningsloy318/vsphere_exporter/collector"
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/vmware/govmomi/simulator"
+)
+
+// goldenESXi holds the metrics of the simulated ESXi host, it is maintained by the collector tests
+const goldenESXi = "collector/testdata/esxi.prom"
+
+// startTestESXi serves a simulated ESXi host whose vms have an uptime of an hour, as the collector tests expect
+func startTestESXi(t *testing.T) string {
+	t.Helper()
+	model := simulator.ESX()
+	if err := model.Create(); err != nil {
+		t.Fatalf("Error when creating simulator model, %v", err)
+	}
+	for _, entity := range model.Map().All("VirtualMachine") {
+		entity.(*simulator.VirtualMachine).Summary.QuickStats.UptimeSeconds = 3600
+	}
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+	t.Cleanup(func() {
+		server.Close()
+		model.Remove()
+	})
+	return server.URL.Host
+}
+
+// responseGatherer parses the metrics served by the handler for the request
+func responseGatherer(t *testing.T, handler http.Handler, url string) prometheus.Gatherer {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", url, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET %s: expected 200, got %d %s", url, recorder.Code, recorder.Body.String())
+	}
+	return textGatherer(t, recorder.Body)
+}
+
+// textGatherer parses metrics in the text exposition format
+func textGatherer(t *testing.T, in io.Reader) prometheus.Gatherer {
+	t.Helper()
+	var parser expfmt.TextParser
+	metricFamilies, err := parser.TextToMetricFamilies(in)
+	if err != nil {
+		t.Fatalf("Error when parsing metrics, %v", err)
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		var result []*dto.MetricFamily
+		for _, metricFamily := range metricFamilies {
+			result = append(result, metricFamily)
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].GetName() < result[j].GetName() })
+		return result, nil
+	})
+}
+
+// goldenNames returns the names of the metric families in the golden file, so the exporter metrics are left out of the comparison
+func goldenNames(t *testing.T) []string {
+	t.Helper()
+	golden, err := os.Open(goldenESXi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer golden.Close()
+	var parser expfmt.TextParser
+	metricFamilies, err := parser.TextToMetricFamilies(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range metricFamilies {
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestMetricsHandler(t *testing.T) {
+	target := startTestESXi(t)
+	credentials := config.ClusterConfig{Username: "user", Password: "pass"}
+	scrapeLimiter = collector.NewScrapeLimiter(1, 1)
+	defer func(c *config.Config) { sc.C = c }(sc.C)
+
+	tests := []struct {
+		name string
+		c    *config.Config
+		url  string
+	}{
+		{"single", &config.Config{Mode: "single", EnabledCluster: target, Clusters: map[string]config.ClusterConfig{target: credentials}}, "/vsphere"},
+		{"multi", &config.Config{Mode: "multi", Clusters: map[string]config.ClusterConfig{target: credentials}}, "/vsphere?target=" + target},
+		{"multi default credentials", &config.Config{Mode: "multi", Clusters: map[string]config.ClusterConfig{"default": credentials}}, "/vsphere?target=" + target},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sc.C = test.c
+			gatherer := responseGatherer(t, metricsHandler(), test.url)
+			golden, err := os.Open(goldenESXi)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer golden.Close()
+			if err := testutil.GatherAndCompare(gatherer, golden, goldenNames(t)...); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestCredentialsHandler(t *testing.T) {
+	defer func(c *config.Config) { sc.C = c }(sc.C)
+	sc.C = &config.Config{
+		Mode:              "multi",
+		ListedTargetsOnly: true,
+		Clusters:          map[string]config.ClusterConfig{"vc01": {Username: "user"}, "vc02": {Username: "user"}},
+		Callers: map[string]config.CallerConfig{
+			"team-a": {Modules: []string{"clusters[vc01]"}},
+			"team-b": {Targets: []string{"vc02", "vc03"}},
+		},
+	}
+
+	tests := []struct {
+		caller string
+		target string
+		code   int
+		reason string
+	}{
+		{"team-a", "vc01", http.StatusOK, ""},
+		{"team-a", "vc02", http.StatusForbidden, "caller_not_allowed"},
+		{"team-b", "vc02", http.StatusOK, ""},
+		{"team-b", "vc03", http.StatusForbidden, "target_not_listed"},
+		{"admin", "vc01", http.StatusForbidden, "caller_not_allowed"},
+	}
+	for _, test := range tests {
+		var before float64
+		if test.reason != "" {
+			before = testutil.ToFloat64(targetRejections.WithLabelValues(test.reason))
+		}
+		request := httptest.NewRequest("GET", "/debug/credentials?target="+test.target, nil)
+		request.SetBasicAuth(test.caller, "pass")
+		recorder := httptest.NewRecorder()
+		credentialsHandler().ServeHTTP(recorder, request)
+		if recorder.Code != test.code {
+			t.Errorf("caller %s, target %s: expected %d, got %d %s", test.caller, test.target, test.code, recorder.Code, recorder.Body.String())
+		}
+		if test.reason != "" && testutil.ToFloat64(targetRejections.WithLabelValues(test.reason)) != before+1 {
+			t.Errorf("caller %s, target %s: expected a %s rejection to be counted", test.caller, test.target, test.reason)
+		}
+	}
+}
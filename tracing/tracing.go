@@ -0,0 +1,93 @@
+// Package tracing sets up the OpenTelemetry traces of the scrapes, exported over OTLP/HTTP.
+// Without --tracing.endpoint the spans are not recorded, only the trace context of the requests is propagated.
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	endpoint = kingpin.Flag(
+		"tracing.endpoint",
+		"OTLP/HTTP endpoint the traces are sent to, e.g. localhost:4318, tracing is disabled if empty.",
+	).Default("").String()
+	insecure = kingpin.Flag(
+		"tracing.insecure",
+		"Send the traces over plain http instead of https.",
+	).Default("false").Bool()
+	sampleRatio = kingpin.Flag(
+		"tracing.sample-ratio",
+		"Ratio of the scrapes traced, unless the scrape request carries a sampled trace context.",
+	).Default("1").Float64()
+
+	// Tracer starts the spans of the exporter, it is a no-op until Setup installs a tracer provider
+	Tracer = otel.Tracer("github.com/jenningsloy318/vsphere_exporter")
+)
+
+// Attribute keys of the spans
+const (
+	TargetKey    = attribute.Key("vsphere.target")
+	CollectorKey = attribute.Key("vsphere.collector")
+	MethodKey    = attribute.Key("vsphere.method")
+	ResultKey    = attribute.Key("vsphere.result")
+	ObjectsKey   = attribute.Key("vsphere.objects")
+	SeriesKey    = attribute.Key("vsphere.series")
+	// RequestBytesKey and ResponseBytesKey are the sizes of the bodies of a vSphere API call
+	RequestBytesKey  = attribute.Key("http.request.body.size")
+	ResponseBytesKey = attribute.Key("http.response.body.size")
+)
+
+// Setup installs the trace context propagator and, with --tracing.endpoint, the tracer provider exporting the spans.
+// The returned function flushes the pending spans and stops the export.
+func Setup(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if *endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(*endpoint)}
+	if *insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", "vsphere_exporter")))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// StartRequest extracts the trace context of an incoming scrape request and starts its server span
+func StartRequest(r *http.Request, name string) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return Tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+}
+
+// EndWithError records the error, if any, on the span and ends it
+func EndWithError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,162 @@
+package vmware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jenningsloy318/vsphere_exporter/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/govmomi/vim25/soap"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Metrics of the calls to the vSphere API, they are exposed on /metrics
+var (
+	apiCallDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "vsphere",
+			Subsystem: "exporter",
+			Name:      "api_call_duration_seconds",
+			Help:      "Duration of the vSphere API calls by target and method.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+		},
+		[]string{"target", "method"},
+	)
+	apiFaults = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "vsphere",
+			Subsystem: "exporter",
+			Name:      "api_faults_total",
+			Help:      "Number of failed vSphere API calls by target, method and fault type.",
+		},
+		[]string{"target", "method", "fault"},
+	)
+	apiRequestBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "vsphere",
+			Subsystem: "exporter",
+			Name:      "api_request_bytes_total",
+			Help:      "Bytes sent to the vSphere API by target and method.",
+		},
+		[]string{"target", "method"},
+	)
+	apiResponseBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "vsphere",
+			Subsystem: "exporter",
+			Name:      "api_response_bytes_total",
+			Help:      "Bytes received from the vSphere API by target and method.",
+		},
+		[]string{"target", "method"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(apiCallDuration, apiFaults, apiRequestBytes, apiResponseBytes)
+}
+
+// methodKey is the context key of the SOAP method, so the HTTP transport can label the bytes it counts
+type methodKey struct{}
+
+// instrumentedRoundTripper wraps the SOAP round tripper of a client and observes every call
+type instrumentedRoundTripper struct {
+	target       string
+	roundTripper soap.RoundTripper
+}
+
+func (rt *instrumentedRoundTripper) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	return rt.observe(ctx, soapMethod(req), func(ctx context.Context) error {
+		return rt.roundTripper.RoundTrip(ctx, req, res)
+	})
+}
+
+// observe runs the call of the given method, recording its duration and fault, and traces it as a client span
+func (rt *instrumentedRoundTripper) observe(ctx context.Context, method string, call func(context.Context) error) error {
+	ctx, span := tracing.Tracer.Start(ctx, method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(tracing.TargetKey.String(rt.target), tracing.MethodKey.String(method)))
+	start := time.Now()
+	err := call(context.WithValue(ctx, methodKey{}, method))
+	apiCallDuration.WithLabelValues(rt.target, method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		apiFaults.WithLabelValues(rt.target, method, faultType(err)).Inc()
+		span.SetAttributes(attribute.String("vsphere.fault", faultType(err)))
+	}
+	tracing.EndWithError(span, err)
+	return err
+}
+
+// instrumentedTransport counts the bytes of the HTTP requests and responses carrying the SOAP calls
+type instrumentedTransport struct {
+	target    string
+	transport http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	method, _ := req.Context().Value(methodKey{}).(string)
+	// the sizes are set on the span of the call once the bodies are closed
+	span := trace.SpanFromContext(req.Context())
+	if req.Body != nil {
+		// the request must not be modified, the body is counted on a copy
+		req = req.Clone(req.Context())
+		req.Body = &countingReadCloser{ReadCloser: req.Body, counter: apiRequestBytes.WithLabelValues(t.target, method), span: span, key: tracing.RequestBytesKey}
+	}
+	res, err := t.transport.RoundTrip(req)
+	if err == nil {
+		res.Body = &countingReadCloser{ReadCloser: res.Body, counter: apiResponseBytes.WithLabelValues(t.target, method), span: span, key: tracing.ResponseBytesKey}
+	}
+	return res, err
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	counter prometheus.Counter
+	span    trace.Span
+	key     attribute.Key
+	n       int
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.counter.Add(float64(n))
+	r.n += n
+	return n, err
+}
+
+func (r *countingReadCloser) Close() error {
+	r.span.SetAttributes(r.key.Int(r.n))
+	return r.ReadCloser.Close()
+}
+
+// soapMethod returns the method name of a request body, e.g. "RetrievePropertiesEx" for *methods.RetrievePropertiesExBody
+func soapMethod(req soap.HasFault) string {
+	t := reflect.TypeOf(req)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return strings.TrimSuffix(t.Name(), "Body")
+}
+
+// faultType returns the type of the vim fault carried by err, e.g. "InvalidLogin", or "TransportError" if the call didn't get a fault back
+func faultType(err error) string {
+	var fault interface{}
+	switch {
+	case soap.IsSoapFault(err):
+		fault = soap.ToSoapFault(err).VimFault()
+		if fault == nil {
+			return soap.ToSoapFault(err).Code
+		}
+	case soap.IsVimFault(err):
+		fault = soap.ToVimFault(err)
+	default:
+		return "TransportError"
+	}
+	t := reflect.TypeOf(fault)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
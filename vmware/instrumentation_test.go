@@ -0,0 +1,58 @@
+package vmware
+
+import (
+	"context"
+	"crypto/tls"
+	"net/url"
+	"testing"
+
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/vmware/govmomi/simulator"
+)
+
+func TestAPIInstrumentation(t *testing.T) {
+	vmc := newTestVMClient(t, simulator.VPX())
+	target := vmc.govmomiClient.URL().Host
+
+	if _, err := vmc.ListHost(); err != nil {
+		t.Fatalf("Error when listing hosts, %v", err)
+	}
+
+	for _, method := range []string{"RetrieveServiceContent", "Login", "CreateContainerView", "RetrievePropertiesEx"} {
+		var duration dto.Metric
+		if err := apiCallDuration.WithLabelValues(target, method).(prometheus.Histogram).Write(&duration); err != nil {
+			t.Fatal(err)
+		}
+		if count := duration.GetHistogram().GetSampleCount(); count == 0 {
+			t.Errorf("expected the duration of %s to be observed, got %d samples", method, count)
+		}
+		if bytes := testutil.ToFloat64(apiResponseBytes.WithLabelValues(target, method)); bytes <= 0 {
+			t.Errorf("expected response bytes for %s, got %v", method, bytes)
+		}
+		if bytes := testutil.ToFloat64(apiRequestBytes.WithLabelValues(target, method)); bytes <= 0 {
+			t.Errorf("expected request bytes for %s, got %v", method, bytes)
+		}
+	}
+}
+
+func TestAPIInstrumentationFaults(t *testing.T) {
+	model := simulator.VPX()
+	if err := model.Create(); err != nil {
+		t.Fatalf("Error when creating simulator model, %v", err)
+	}
+	model.Service.TLS = new(tls.Config)
+	model.Service.Listen = &url.URL{User: url.UserPassword("user", "pass")}
+	server := model.Service.NewServer()
+	defer model.Remove()
+	defer server.Close()
+
+	if _, err := NewVMClient(context.Background(), server.URL.Host, &config.ClusterConfig{Username: "user", Password: "wrong"}); err == nil {
+		t.Fatalf("expected the login to fail")
+	}
+	if faults := testutil.ToFloat64(apiFaults.WithLabelValues(server.URL.Host, "Login", "InvalidLogin")); faults != 1 {
+		t.Errorf("expected 1 InvalidLogin fault, got %v", faults)
+	}
+}
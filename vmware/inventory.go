@@ -0,0 +1,108 @@
+package vmware
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Inventory resolves managed object references of one vCenter to their names and parents.
+// It is created once per scrape and shared by all collectors, the managed entities are retrieved lazily on first use.
+type Inventory struct {
+	source   Source
+	once     sync.Once
+	err      error
+	entities map[types.ManagedObjectReference]inventoryEntity
+}
+
+type inventoryEntity struct {
+	name   string
+	parent *types.ManagedObjectReference
+}
+
+// NewInventory returns an inventory backed by the given source
+func NewInventory(source Source) *Inventory {
+	return &Inventory{
+		source:   source,
+		entities: map[types.ManagedObjectReference]inventoryEntity{},
+	}
+}
+
+// Load retrieves the name and parent of every managed entity, it is only executed once per inventory
+func (inv *Inventory) Load() error {
+	inv.once.Do(func() {
+		inv.err = inv.load()
+	})
+	return inv.err
+}
+
+func (inv *Inventory) load() error {
+	entityList, err := inv.source.ListEntities()
+	if err != nil {
+		return err
+	}
+
+	for _, entity := range entityList {
+		var item inventoryEntity
+		for _, prop := range entity.PropSet {
+			switch prop.Name {
+			case "name":
+				item.name, _ = prop.Val.(string)
+			case "parent":
+				if parent, ok := prop.Val.(types.ManagedObjectReference); ok {
+					item.parent = &parent
+				}
+			}
+		}
+		inv.entities[entity.Obj] = item
+	}
+	return nil
+}
+
+// Name returns the name of the referenced entity, or the reference value if the entity is unknown
+func (inv *Inventory) Name(ref types.ManagedObjectReference) string {
+	if inv.Load() == nil {
+		if entity, ok := inv.entities[ref]; ok {
+			return entity.name
+		}
+	}
+	return ref.Value
+}
+
+// Parent returns the parent of the referenced entity
+func (inv *Inventory) Parent(ref types.ManagedObjectReference) (types.ManagedObjectReference, bool) {
+	if inv.Load() != nil {
+		return types.ManagedObjectReference{}, false
+	}
+	entity, ok := inv.entities[ref]
+	if !ok || entity.parent == nil {
+		return types.ManagedObjectReference{}, false
+	}
+	return *entity.parent, true
+}
+
+// Ancestor returns the closest ancestor of the referenced entity with the given type, e.g. "Datacenter" or "ClusterComputeResource"
+func (inv *Inventory) Ancestor(ref types.ManagedObjectReference, kind string) (types.ManagedObjectReference, bool) {
+	for parent, ok := inv.Parent(ref); ok; parent, ok = inv.Parent(parent) {
+		if parent.Type == kind {
+			return parent, true
+		}
+	}
+	return types.ManagedObjectReference{}, false
+}
+
+// Path returns the inventory path of the referenced entity, e.g. "/DC0/host/DC0_C0/DC0_C0_H0"
+func (inv *Inventory) Path(ref types.ManagedObjectReference) string {
+	if inv.Load() != nil {
+		return ""
+	}
+	var names []string
+	for current, ok := ref, true; ok; current, ok = inv.Parent(current) {
+		if _, known := inv.entities[current]; !known {
+			break
+		}
+		names = append([]string{inv.Name(current)}, names...)
+	}
+	return "/" + strings.Join(names, "/")
+}
@@ -0,0 +1,49 @@
+package vmware
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/mo"
+)
+
+func TestInventory(t *testing.T) {
+	vmc := newTestVMClient(t, simulator.VPX())
+
+	hosts, err := vmc.ListHost()
+	if err != nil {
+		t.Fatalf("Error when listing hosts, %v", err)
+	}
+	var host *mo.HostSystem
+	for i := range hosts {
+		if hosts[i].Summary.Config.Name == "DC0_C0_H0" {
+			host = &hosts[i]
+		}
+	}
+	if host == nil {
+		t.Fatalf("host DC0_C0_H0 not found in %d hosts", len(hosts))
+	}
+
+	inventory := NewInventory(vmc)
+	if err := inventory.Load(); err != nil {
+		t.Fatalf("Error when loading inventory, %v", err)
+	}
+
+	if name := inventory.Name(host.Self); name != "DC0_C0_H0" {
+		t.Errorf("expected host name DC0_C0_H0, got %s", name)
+	}
+	cluster, ok := inventory.Ancestor(host.Self, "ClusterComputeResource")
+	if !ok || inventory.Name(cluster) != "DC0_C0" {
+		t.Errorf("expected cluster DC0_C0, got %v", cluster)
+	}
+	datacenter, ok := inventory.Ancestor(host.Self, "Datacenter")
+	if !ok || inventory.Name(datacenter) != "DC0" {
+		t.Errorf("expected datacenter DC0, got %v", datacenter)
+	}
+	if _, ok := inventory.Ancestor(host.Self, "VirtualApp"); ok {
+		t.Errorf("expected no VirtualApp ancestor")
+	}
+	if path := inventory.Path(host.Self); path != "/DC0/host/DC0_C0/DC0_C0_H0" {
+		t.Errorf("expected path /DC0/host/DC0_C0/DC0_C0_H0, got %s", path)
+	}
+}
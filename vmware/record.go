@@ -0,0 +1,260 @@
+package vmware
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/vmware/govmomi/performance"
+	"github.com/vmware/govmomi/vim25/json"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	recordDir = kingpin.Flag(
+		"record.dir",
+		"Directory the property collector results of every scrape are saved to, scrubbed of secrets, in a sub-directory per target.",
+	).Default("").String()
+	replayDir = kingpin.Flag(
+		"replay.dir",
+		"Directory of recordings made with --record.dir, which are served instead of connecting to the targets.",
+	).Default("").String()
+)
+
+// scrubbed replaces the values which may carry secrets in the recordings
+const scrubbed = "<scrubbed>"
+
+// unsafeTargetChars are the characters of a target not kept in the name of its recording directory
+var unsafeTargetChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+var (
+	dirLocksMu sync.Mutex
+	// dirLocks serialize the reads and writes of the recordings of a directory, e.g. by concurrent scrapes of a target
+	dirLocks = map[string]*sync.Mutex{}
+)
+
+// dirLock returns the lock of the recording directory
+func dirLock(dir string) *sync.Mutex {
+	dirLocksMu.Lock()
+	defer dirLocksMu.Unlock()
+	lock, ok := dirLocks[dir]
+	if !ok {
+		lock = &sync.Mutex{}
+		dirLocks[dir] = lock
+	}
+	return lock
+}
+
+// recording is the directory holding the results retrieved from one target, one JSON file per result,
+// e.g. hosts.json, in the VMOMI JSON encoding, so the types of interface values survive a replay
+type recording struct {
+	// Mutex guards the perf records
+	sync.Mutex
+	dir string
+	// dirLock is shared by the recordings of the same directory
+	dirLock *sync.Mutex
+	// perfIntervals and perfQueries collect the perf intervals and samples queried during a scrape
+	perfIntervals []perfIntervalRecord
+	perfQueries   []perfQueryRecord
+}
+
+// perfQueryRecord is a recorded perf query and its result
+type perfQueryRecord struct {
+	Entities []types.ManagedObjectReference `json:"entities"`
+	Counters []string                       `json:"counters"`
+	Interval int32                          `json:"interval"`
+	Series   []performance.EntityMetric     `json:"series"`
+}
+
+// perfIntervalRecord is the recorded perf interval of an entity
+type perfIntervalRecord struct {
+	Entity   types.ManagedObjectReference `json:"entity"`
+	Interval int32                        `json:"interval"`
+}
+
+// taggedObjectRecord is a managed object and the tags attached to it
+type taggedObjectRecord struct {
+	Object types.ManagedObjectReference `json:"object"`
+	Tags   []string                     `json:"tags"`
+}
+
+// taggedObjects returns the tags of the recorded objects, keyed by the object reference
+func taggedObjects(taggedObjectList []taggedObjectRecord) map[types.ManagedObjectReference][]string {
+	objectTags := make(map[types.ManagedObjectReference][]string, len(taggedObjectList))
+	for _, taggedObject := range taggedObjectList {
+		objectTags[taggedObject.Object] = taggedObject.Tags
+	}
+	return objectTags
+}
+
+// privilegesGranted reports whether each of the privileges is granted, a privilege missing from the list is an error
+func privilegesGranted(privilegeList []types.PrivilegeAvailability, privileges []string) (map[string]bool, error) {
+	available := make(map[string]bool, len(privilegeList))
+	for _, privilege := range privilegeList {
+		available[privilege.PrivId] = privilege.IsGranted
+	}
+	granted := make(map[string]bool, len(privileges))
+	for _, privilege := range privileges {
+		isGranted, ok := available[privilege]
+		if !ok {
+			return nil, fmt.Errorf("no availability of privilege %s", privilege)
+		}
+		granted[privilege] = isGranted
+	}
+	return granted, nil
+}
+
+// newRecording returns the recording of the target in the base directory, a target whose name would leave it is an error
+func newRecording(baseDir string, target string) (*recording, error) {
+	name := unsafeTargetChars.ReplaceAllString(target, "_")
+	if name == "" || name == "." || name == ".." {
+		return nil, fmt.Errorf("target %q can't be recorded", target)
+	}
+	dir := filepath.Join(baseDir, name)
+	return &recording{dir: dir, dirLock: dirLock(dir)}, nil
+}
+
+// newRecordingEncoder returns a VMOMI JSON encoder which, unlike types.NewJSONEncoder, doesn't wrap the root value,
+// so lists of managed objects can be decoded again
+func newRecordingEncoder(w io.Writer) *json.Encoder {
+	encoder := json.NewEncoder(w)
+	encoder.SetDiscriminator("_typeName", "_value", json.DiscriminatorEncodeTypeNameAllObjects)
+	encoder.SetTypeToDiscriminatorFunc(types.VmomiTypeName)
+	return encoder
+}
+
+// load decodes the named result into result, which is a pointer
+func (r *recording) load(name string, result interface{}) error {
+	r.dirLock.Lock()
+	defer r.dirLock.Unlock()
+	data, err := ioutil.ReadFile(filepath.Join(r.dir, name+".json"))
+	if err != nil {
+		return fmt.Errorf("error when reading recording %s, %v", name, err)
+	}
+	if err := types.NewJSONDecoder(bytes.NewReader(data)).Decode(result); err != nil {
+		return fmt.Errorf("error when decoding recording %s, %v", name, err)
+	}
+	return nil
+}
+
+// save scrubs a copy of the result, which is a pointer, and writes it as the named result
+func (r *recording) save(name string, result interface{}) error {
+	var buf bytes.Buffer
+	if err := newRecordingEncoder(&buf).Encode(result); err != nil {
+		return err
+	}
+	// the result is still used by the collectors, so a copy is scrubbed
+	scrubbedResult := reflect.New(reflect.TypeOf(result).Elem()).Interface()
+	if err := types.NewJSONDecoder(&buf).Decode(scrubbedResult); err != nil {
+		return err
+	}
+	Scrub(scrubbedResult)
+
+	buf.Reset()
+	encoder := newRecordingEncoder(&buf)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(scrubbedResult); err != nil {
+		return err
+	}
+
+	r.dirLock.Lock()
+	defer r.dirLock.Unlock()
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(r.dir, name+".json"), buf.Bytes(), 0644)
+}
+
+// Scrub removes the values which may carry secrets, i.e. the advanced options of hosts and the extra config and vApp properties of vms,
+// such as guestinfo variables holding passwords, whether they were listed or retrieved as properties.
+// The result is a pointer to a list of hosts, vms or object contents, it is scrubbed in place.
+func Scrub(result interface{}) {
+	switch result := result.(type) {
+	case *[]mo.HostSystem:
+		for i := range *result {
+			if hostConfig := (*result)[i].Config; hostConfig != nil {
+				scrubOptionValues(hostConfig.Option)
+			}
+		}
+	case *[]mo.VirtualMachine:
+		for i := range *result {
+			vmConfig := (*result)[i].Config
+			if vmConfig == nil {
+				continue
+			}
+			scrubOptionValues(vmConfig.ExtraConfig)
+			scrubVAppConfig(vmConfig.VAppConfig)
+		}
+	case *[]types.ObjectContent:
+		for i := range *result {
+			propSet := (*result)[i].PropSet
+			for j := range propSet {
+				if secretProperty(propSet[j].Name) {
+					propSet[j].Val = scrubProperty(propSet[j].Val)
+				}
+			}
+		}
+	}
+}
+
+// secretProperty reports whether the property path is, or contains, the options of hosts or the extra config or vApp config of vms
+func secretProperty(path string) bool {
+	if path == "config" {
+		return true
+	}
+	for _, secretPath := range []string{"config.extraConfig", "config.option", "config.vAppConfig"} {
+		if path == secretPath || strings.HasPrefix(path, secretPath+".") || strings.HasPrefix(path, secretPath+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// scrubProperty returns the value of a property with secrets scrubbed, the values it doesn't know are replaced as a whole
+func scrubProperty(val interface{}) interface{} {
+	switch val := val.(type) {
+	case types.VirtualMachineConfigInfo:
+		scrubOptionValues(val.ExtraConfig)
+		scrubVAppConfig(val.VAppConfig)
+		return val
+	case types.HostConfigInfo:
+		scrubOptionValues(val.Option)
+		return val
+	case types.ArrayOfOptionValue:
+		scrubOptionValues(val.OptionValue)
+		return val
+	case types.VmConfigInfo:
+		scrubVAppConfig(&val)
+		return val
+	case types.VAppConfigInfo:
+		scrubVAppConfig(&val)
+		return val
+	}
+	return scrubbed
+}
+
+func scrubVAppConfig(config types.BaseVmConfigInfo) {
+	if config == nil {
+		return
+	}
+	vAppConfig := config.GetVmConfigInfo()
+	for i := range vAppConfig.Property {
+		vAppConfig.Property[i].Value = scrubbed
+		vAppConfig.Property[i].DefaultValue = scrubbed
+	}
+}
+
+func scrubOptionValues(options []types.BaseOptionValue) {
+	for _, option := range options {
+		option.GetOptionValue().Value = scrubbed
+	}
+}
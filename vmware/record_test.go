@@ -0,0 +1,211 @@
+package vmware
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	*recordDir = dir
+	defer func() { *recordDir = "" }()
+
+	model := simulator.VPX()
+	vc := newTestVMClient(t, model)
+	target := vc.govmomiClient.URL().Host
+	for _, entity := range model.Map().All("VirtualMachine") {
+		vm := entity.(*simulator.VirtualMachine)
+		vm.Config.ExtraConfig = append(vm.Config.ExtraConfig, &types.OptionValue{Key: "guestinfo.password", Value: "s3cret"})
+	}
+	for _, entity := range model.Map().All("HostSystem") {
+		host := entity.(*simulator.HostSystem)
+		host.Config.Option = append(host.Config.Option, &types.OptionValue{Key: "Config.Defaults.password", Value: "s3cret"})
+	}
+
+	hosts, err := vc.ListHost()
+	if err != nil {
+		t.Fatalf("Error when listing hosts, %v", err)
+	}
+	vms, err := vc.ListVirtualMachine()
+	if err != nil {
+		t.Fatalf("Error when listing virtual machines, %v", err)
+	}
+	entities, err := vc.ListEntities()
+	if err != nil {
+		t.Fatalf("Error when listing entities, %v", err)
+	}
+	perfCounters, err := vc.ListPerfCounters()
+	if err != nil {
+		t.Fatalf("Error when listing perf counters, %v", err)
+	}
+	perfInterval, err := vc.PerfInterval(hosts[0].Self)
+	if err != nil {
+		t.Fatalf("Error when getting the perf interval, %v", err)
+	}
+	clusters, err := vc.ListCluster()
+	if err != nil || len(clusters) != model.Cluster {
+		t.Fatalf("expected %d clusters, got %d, %v", model.Cluster, len(clusters), err)
+	}
+	perfSeries, err := vc.QueryPerf([]types.ManagedObjectReference{hosts[0].Self}, []string{"cpu.usage.average"}, perfInterval)
+	if err != nil || len(perfSeries) != 1 || len(perfSeries[0].Value) == 0 {
+		t.Fatalf("expected a cpu usage sample of %s, got %#v, %v", hosts[0].Self, perfSeries, err)
+	}
+	// the live results aren't scrubbed
+	if vms[0].Config.ExtraConfig[len(vms[0].Config.ExtraConfig)-1].GetOptionValue().Value != "s3cret" {
+		t.Errorf("expected the live result to be left alone")
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("expected recordings in %s, %v", dir, err)
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "s3cret") {
+			t.Errorf("expected %s to be scrubbed of secrets", file)
+		}
+	}
+
+	// the recordings are served without a target
+	*recordDir = ""
+	*replayDir = dir
+	defer func() { *replayDir = "" }()
+	replay, err := NewSource(context.Background(), target, nil)
+	if err != nil {
+		t.Fatalf("Error when replaying %s, %v", target, err)
+	}
+	defer replay.Logout()
+
+	if !replay.IsVCenter() || replay.About() != vc.About() {
+		t.Errorf("expected the recorded product information, got %#v", replay.About())
+	}
+	replayedHosts, err := replay.ListHost()
+	if err != nil || len(replayedHosts) != len(hosts) {
+		t.Fatalf("expected %d recorded hosts, got %d, %v", len(hosts), len(replayedHosts), err)
+	}
+	for i, host := range replayedHosts {
+		if host.Self != hosts[i].Self || host.Summary.Config.Name != hosts[i].Summary.Config.Name || host.Runtime.ConnectionState != hosts[i].Runtime.ConnectionState {
+			t.Errorf("expected recorded host %s, got %s", hosts[i].Summary.Config.Name, host.Summary.Config.Name)
+		}
+	}
+	replayedVMs, err := replay.ListVirtualMachine()
+	if err != nil || len(replayedVMs) != len(vms) {
+		t.Fatalf("expected %d recorded virtual machines, got %d, %v", len(vms), len(replayedVMs), err)
+	}
+	for _, vm := range replayedVMs {
+		for _, option := range vm.Config.ExtraConfig {
+			if option.GetOptionValue().Value != scrubbed {
+				t.Errorf("expected option %s of %s to be scrubbed", option.GetOptionValue().Key, vm.Name)
+			}
+		}
+	}
+	replayedEntities, err := replay.ListEntities()
+	if err != nil || len(replayedEntities) != len(entities) {
+		t.Errorf("expected %d recorded entities, got %d, %v", len(entities), len(replayedEntities), err)
+	}
+	replayedPerfCounters, err := replay.ListPerfCounters()
+	if err != nil || len(replayedPerfCounters) != len(perfCounters) {
+		t.Errorf("expected %d recorded perf counters, got %d, %v", len(perfCounters), len(replayedPerfCounters), err)
+	}
+	if interval, err := replay.PerfInterval(hosts[0].Self); err != nil || interval != perfInterval {
+		t.Errorf("expected the recorded perf interval %d, got %d, %v", perfInterval, interval, err)
+	}
+	replayedClusters, err := replay.ListCluster()
+	if err != nil || len(replayedClusters) != len(clusters) || replayedClusters[0].Name != clusters[0].Name {
+		t.Errorf("expected the recorded clusters, got %#v, %v", replayedClusters, err)
+	}
+	replayedSeries, err := replay.QueryPerf([]types.ManagedObjectReference{hosts[0].Self}, []string{"cpu.usage.average"}, perfInterval)
+	if err != nil || len(replayedSeries) != 1 || !reflect.DeepEqual(replayedSeries[0].Value, perfSeries[0].Value) {
+		t.Errorf("expected the recorded cpu usage sample %#v, got %#v, %v", perfSeries, replayedSeries, err)
+	}
+	if _, err := replay.QueryPerf([]types.ManagedObjectReference{hosts[0].Self}, []string{"mem.usage.average"}, perfInterval); err == nil {
+		t.Errorf("expected an error for a query which wasn't recorded")
+	}
+	if _, err := replay.ListDatastore(); err == nil {
+		t.Errorf("expected an error for a result which wasn't recorded")
+	}
+}
+
+func TestRecordPropertiesScrubbed(t *testing.T) {
+	dir := t.TempDir()
+	*recordDir = dir
+	defer func() { *recordDir = "" }()
+
+	model := simulator.VPX()
+	vc := newTestVMClient(t, model)
+	for _, entity := range model.Map().All("VirtualMachine") {
+		vm := entity.(*simulator.VirtualMachine)
+		vm.Config.ExtraConfig = append(vm.Config.ExtraConfig, &types.OptionValue{Key: "guestinfo.password", Value: "s3cret"})
+		vm.Config.VAppConfig = &types.VmConfigInfo{Property: []types.VAppPropertyInfo{{Key: 1, Id: "password", Value: "s3cret", DefaultValue: "s3cret"}}}
+	}
+	for _, entity := range model.Map().All("HostSystem") {
+		host := entity.(*simulator.HostSystem)
+		host.Config.Option = append(host.Config.Option, &types.OptionValue{Key: "Config.Defaults.password", Value: "s3cret"})
+	}
+
+	for _, test := range []struct {
+		objectType string
+		paths      []string
+	}{
+		{"VirtualMachine", []string{"config"}},
+		{"VirtualMachine", []string{"config.extraConfig", "config.vAppConfig"}},
+		{"VirtualMachine", []string{"config.vAppConfig.property"}},
+		{"VirtualMachine", nil},
+		{"HostSystem", []string{"config"}},
+		{"HostSystem", []string{"config.option"}},
+		{"HostSystem", nil},
+	} {
+		objects, err := vc.RetrieveProperties(test.objectType, test.paths)
+		if err != nil || len(objects) == 0 {
+			t.Fatalf("Error when retrieving %v of %s, %v", test.paths, test.objectType, err)
+		}
+		// the recording of the type is overwritten by every retrieval
+		data, err := ioutil.ReadFile(filepath.Join(vc.recorder.dir, "properties_"+test.objectType+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the brackets of scrubbed are escaped in the recordings
+		if !strings.Contains(string(data), strings.Trim(scrubbed, "<>")) {
+			t.Errorf("expected %v of %s to be recorded scrubbed", test.paths, test.objectType)
+		}
+		if strings.Contains(string(data), "s3cret") {
+			t.Errorf("expected %v of %s to be scrubbed of secrets", test.paths, test.objectType)
+		}
+	}
+}
+
+func TestRecordingDir(t *testing.T) {
+	dir := t.TempDir()
+	for _, target := range []string{"", ".", ".."} {
+		if _, err := newRecording(dir, target); err == nil {
+			t.Errorf("expected target %q not to be recorded", target)
+		}
+	}
+	for _, target := range []string{"../..", "/", "vc01:443", "https://vc01/sdk"} {
+		recording, err := newRecording(dir, target)
+		if err != nil {
+			t.Fatalf("Error when recording target %q, %v", target, err)
+		}
+		if filepath.Dir(recording.dir) != dir {
+			t.Errorf("expected the recording of target %q in %s, got %s", target, dir, recording.dir)
+		}
+	}
+
+	// the recordings of the same target share the lock of their directory
+	first, _ := newRecording(dir, "vc01")
+	second, _ := newRecording(dir, "vc01")
+	other, _ := newRecording(dir, "vc02")
+	if first.dirLock != second.dirLock || first.dirLock == other.dirLock {
+		t.Errorf("expected the recordings of a directory to share its lock")
+	}
+}
@@ -0,0 +1,167 @@
+package vmware
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/jenningsloy318/vsphere_exporter/logging"
+	"github.com/vmware/govmomi/performance"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// ReplaySource serves the recordings of a target made with --record.dir, see VMClient
+type ReplaySource struct {
+	recording *recording
+	about     types.AboutInfo
+}
+
+// NewReplaySource returns the source of the target recorded in dir
+func NewReplaySource(dir string, target string) (*ReplaySource, error) {
+	recording, err := newRecording(dir, target)
+	if err != nil {
+		logging.Logger().Error("error when replaying target", "target", target, "dir", dir, "err", err)
+		return nil, err
+	}
+	source := &ReplaySource{recording: recording}
+	if err := source.recording.load("about", &source.about); err != nil {
+		logging.Logger().Error("error when replaying target", "target", target, "dir", dir, "err", err)
+		return nil, err
+	}
+	return source, nil
+}
+
+func (r *ReplaySource) About() types.AboutInfo {
+	return r.about
+}
+
+func (r *ReplaySource) IsVCenter() bool {
+	return r.about.ApiType == "VirtualCenter"
+}
+
+func (r *ReplaySource) ListHost() ([]mo.HostSystem, error) {
+	var hostSystemList []mo.HostSystem
+	err := r.recording.load("hosts", &hostSystemList)
+	return hostSystemList, err
+}
+
+func (r *ReplaySource) ListHealthSystemRuntime(hosts []mo.HostSystem) (map[types.ManagedObjectReference]types.HealthSystemRuntime, error) {
+	healthSystemRuntimes := map[types.ManagedObjectReference]types.HealthSystemRuntime{}
+	hostMapping := map[types.ManagedObjectReference]types.ManagedObjectReference{}
+	for _, host := range hosts {
+		if host.ConfigManager.HealthStatusSystem != nil {
+			hostMapping[*host.ConfigManager.HealthStatusSystem] = host.Self
+		}
+	}
+	if len(hostMapping) == 0 {
+		return healthSystemRuntimes, nil
+	}
+
+	var healthStatusSystemList []mo.HostHealthStatusSystem
+	if err := r.recording.load("health_status_systems", &healthStatusSystemList); err != nil {
+		return nil, err
+	}
+	for _, healthStatusSystem := range healthStatusSystemList {
+		if host, ok := hostMapping[healthStatusSystem.Self]; ok {
+			healthSystemRuntimes[host] = healthStatusSystem.Runtime
+		}
+	}
+	return healthSystemRuntimes, nil
+}
+
+func (r *ReplaySource) ListVirtualMachine() ([]mo.VirtualMachine, error) {
+	var virtualMachineList []mo.VirtualMachine
+	err := r.recording.load("virtual_machines", &virtualMachineList)
+	return virtualMachineList, err
+}
+
+func (r *ReplaySource) ListDatastore() ([]mo.Datastore, error) {
+	var datastoreList []mo.Datastore
+	err := r.recording.load("datastores", &datastoreList)
+	return datastoreList, err
+}
+
+func (r *ReplaySource) ListNetwork() ([]mo.Network, error) {
+	var networkList []mo.Network
+	err := r.recording.load("networks", &networkList)
+	return networkList, err
+}
+
+func (r *ReplaySource) ListCluster() ([]mo.ClusterComputeResource, error) {
+	var clusterList []mo.ClusterComputeResource
+	err := r.recording.load("clusters", &clusterList)
+	return clusterList, err
+}
+
+func (r *ReplaySource) ListEntities() ([]types.ObjectContent, error) {
+	var entityList []types.ObjectContent
+	err := r.recording.load("entities", &entityList)
+	return entityList, err
+}
+
+// RetrieveProperties returns the recorded objects of the type, with the paths retrieved when recording
+func (r *ReplaySource) RetrieveProperties(objectType string, paths []string) ([]types.ObjectContent, error) {
+	var objectList []types.ObjectContent
+	err := r.recording.load("properties_"+objectType, &objectList)
+	return objectList, err
+}
+
+func (r *ReplaySource) ListTags() (map[types.ManagedObjectReference][]string, error) {
+	var taggedObjectList []taggedObjectRecord
+	if err := r.recording.load("tags", &taggedObjectList); err != nil {
+		return nil, err
+	}
+	return taggedObjects(taggedObjectList), nil
+}
+
+func (r *ReplaySource) PrivilegesGranted(privileges []string) (map[string]bool, error) {
+	var privilegeList []types.PrivilegeAvailability
+	if err := r.recording.load("privileges", &privilegeList); err != nil {
+		return nil, err
+	}
+	return privilegesGranted(privilegeList, privileges)
+}
+
+func (r *ReplaySource) ListPerfCounters() (map[string]*types.PerfCounterInfo, error) {
+	var perfCounterList []types.PerfCounterInfo
+	if err := r.recording.load("perf_counters", &perfCounterList); err != nil {
+		return nil, err
+	}
+	perfCounters := make(map[string]*types.PerfCounterInfo, len(perfCounterList))
+	for i := range perfCounterList {
+		perfCounters[perfCounterList[i].Name()] = &perfCounterList[i]
+	}
+	return perfCounters, nil
+}
+
+func (r *ReplaySource) PerfInterval(entity types.ManagedObjectReference) (int32, error) {
+	var perfIntervals []perfIntervalRecord
+	if err := r.recording.load("perf_intervals", &perfIntervals); err != nil {
+		return 0, err
+	}
+	for _, perfInterval := range perfIntervals {
+		if perfInterval.Entity == entity {
+			return perfInterval.Interval, nil
+		}
+	}
+	return 0, fmt.Errorf("no recorded perf interval for %s", entity)
+}
+
+// QueryPerf returns the recorded result of the same query
+func (r *ReplaySource) QueryPerf(entities []types.ManagedObjectReference, counters []string, interval int32) ([]performance.EntityMetric, error) {
+	var perfQueries []perfQueryRecord
+	if err := r.recording.load("perf_queries", &perfQueries); err != nil {
+		return nil, err
+	}
+	for _, perfQuery := range perfQueries {
+		if perfQuery.Interval == interval && reflect.DeepEqual(perfQuery.Entities, entities) && reflect.DeepEqual(perfQuery.Counters, counters) {
+			return perfQuery.Series, nil
+		}
+	}
+	return nil, fmt.Errorf("no recorded perf query of %v for %d entities", counters, len(entities))
+}
+
+// Logout is a no-op, there is no session to close
+func (r *ReplaySource) Logout() error {
+	return nil
+}
@@ -0,0 +1,192 @@
+package vmware
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/vmware/govmomi/performance"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Source provides the inventory of a target to the collectors. It is implemented by
+//   - VMClient, retrieving the inventory from the target
+//   - ReplaySource, serving the recordings of --record.dir
+//   - CachedSource, keeping the results of another source for a while
+type Source interface {
+	// About returns the product information of the target
+	About() types.AboutInfo
+	// IsVCenter reports if the target is a vCenter, it is false for a standalone ESXi host
+	IsVCenter() bool
+	ListHost() ([]mo.HostSystem, error)
+	// ListHealthSystemRuntime returns the health of the hosts from their HostHealthStatusSystem, keyed by the host reference
+	ListHealthSystemRuntime(hosts []mo.HostSystem) (map[types.ManagedObjectReference]types.HealthSystemRuntime, error)
+	ListVirtualMachine() ([]mo.VirtualMachine, error)
+	ListDatastore() ([]mo.Datastore, error)
+	ListNetwork() ([]mo.Network, error)
+	ListCluster() ([]mo.ClusterComputeResource, error)
+	// ListEntities returns the name and parent of every managed entity
+	ListEntities() ([]types.ObjectContent, error)
+	// RetrieveProperties returns the given property paths of every managed object of the type, e.g. "summary.quickStats" of HostSystem
+	RetrieveProperties(objectType string, paths []string) ([]types.ObjectContent, error)
+	// ListTags returns the tags attached to the managed objects as "<category>/<tag>", keyed by the object reference
+	ListTags() (map[types.ManagedObjectReference][]string, error)
+	// PrivilegesGranted reports whether the session has each of the privileges, e.g. System.Read, on the root folder
+	PrivilegesGranted(privileges []string) (map[string]bool, error)
+	ListPerfCounters() (map[string]*types.PerfCounterInfo, error)
+	// PerfInterval returns the interval in seconds the perf counters of the entity are sampled at
+	PerfInterval(entity types.ManagedObjectReference) (int32, error)
+	// QueryPerf returns the latest sample of the named counters of the entities at the given interval
+	QueryPerf(entities []types.ManagedObjectReference, counters []string, interval int32) ([]performance.EntityMetric, error)
+	Logout() error
+}
+
+// NewSource returns the source of the target, which is its recording with --replay.dir, or the target itself
+func NewSource(ctx context.Context, target string, clusterConfig *config.ClusterConfig) (Source, error) {
+	// the concrete sources are returned as a nil interface on error, not as a typed nil
+	if *replayDir != "" {
+		source, err := NewReplaySource(*replayDir, target)
+		if err != nil {
+			return nil, err
+		}
+		return source, nil
+	}
+	vmc, err := NewVMClient(ctx, target, clusterConfig)
+	if err != nil {
+		return nil, err
+	}
+	return vmc, nil
+}
+
+// CachedSource keeps the results of a source for the given time to live, so collectors asking for the same objects share one retrieval.
+// A time to live of 0 keeps the results as long as the source lives. It is safe for concurrent use.
+type CachedSource struct {
+	source  Source
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	once    sync.Once
+	expires time.Time
+	result  interface{}
+	err     error
+}
+
+// NewCachedSource returns a source caching the results of source
+func NewCachedSource(source Source, ttl time.Duration) *CachedSource {
+	return &CachedSource{
+		source:  source,
+		ttl:     ttl,
+		entries: map[string]*cacheEntry{},
+	}
+}
+
+// cached returns the result of fetch for key, which is only called if the key isn't cached or has expired.
+// Errors are cached too, so a failing call isn't repeated by every collector.
+func (c *CachedSource) cached(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok || (c.ttl > 0 && time.Now().After(entry.expires)) {
+		entry = &cacheEntry{expires: time.Now().Add(c.ttl)}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.result, entry.err = fetch()
+	})
+	return entry.result, entry.err
+}
+
+func (c *CachedSource) About() types.AboutInfo {
+	return c.source.About()
+}
+
+func (c *CachedSource) IsVCenter() bool {
+	return c.source.IsVCenter()
+}
+
+func (c *CachedSource) ListHost() ([]mo.HostSystem, error) {
+	result, err := c.cached("hosts", func() (interface{}, error) { return c.source.ListHost() })
+	hostList, _ := result.([]mo.HostSystem)
+	return hostList, err
+}
+
+// ListHealthSystemRuntime isn't cached as it depends on the given hosts
+func (c *CachedSource) ListHealthSystemRuntime(hosts []mo.HostSystem) (map[types.ManagedObjectReference]types.HealthSystemRuntime, error) {
+	return c.source.ListHealthSystemRuntime(hosts)
+}
+
+func (c *CachedSource) ListVirtualMachine() ([]mo.VirtualMachine, error) {
+	result, err := c.cached("virtual_machines", func() (interface{}, error) { return c.source.ListVirtualMachine() })
+	virtualMachineList, _ := result.([]mo.VirtualMachine)
+	return virtualMachineList, err
+}
+
+func (c *CachedSource) ListDatastore() ([]mo.Datastore, error) {
+	result, err := c.cached("datastores", func() (interface{}, error) { return c.source.ListDatastore() })
+	datastoreList, _ := result.([]mo.Datastore)
+	return datastoreList, err
+}
+
+func (c *CachedSource) ListNetwork() ([]mo.Network, error) {
+	result, err := c.cached("networks", func() (interface{}, error) { return c.source.ListNetwork() })
+	networkList, _ := result.([]mo.Network)
+	return networkList, err
+}
+
+func (c *CachedSource) ListCluster() ([]mo.ClusterComputeResource, error) {
+	result, err := c.cached("clusters", func() (interface{}, error) { return c.source.ListCluster() })
+	clusterList, _ := result.([]mo.ClusterComputeResource)
+	return clusterList, err
+}
+
+func (c *CachedSource) ListEntities() ([]types.ObjectContent, error) {
+	result, err := c.cached("entities", func() (interface{}, error) { return c.source.ListEntities() })
+	entityList, _ := result.([]types.ObjectContent)
+	return entityList, err
+}
+
+func (c *CachedSource) RetrieveProperties(objectType string, paths []string) ([]types.ObjectContent, error) {
+	result, err := c.cached("properties:"+objectType+":"+strings.Join(paths, ","), func() (interface{}, error) { return c.source.RetrieveProperties(objectType, paths) })
+	objectList, _ := result.([]types.ObjectContent)
+	return objectList, err
+}
+
+func (c *CachedSource) ListTags() (map[types.ManagedObjectReference][]string, error) {
+	result, err := c.cached("tags", func() (interface{}, error) { return c.source.ListTags() })
+	objectTags, _ := result.(map[types.ManagedObjectReference][]string)
+	return objectTags, err
+}
+
+func (c *CachedSource) PrivilegesGranted(privileges []string) (map[string]bool, error) {
+	result, err := c.cached("privileges:"+strings.Join(privileges, ","), func() (interface{}, error) { return c.source.PrivilegesGranted(privileges) })
+	granted, _ := result.(map[string]bool)
+	return granted, err
+}
+
+func (c *CachedSource) ListPerfCounters() (map[string]*types.PerfCounterInfo, error) {
+	result, err := c.cached("perf_counters", func() (interface{}, error) { return c.source.ListPerfCounters() })
+	perfCounters, _ := result.(map[string]*types.PerfCounterInfo)
+	return perfCounters, err
+}
+
+func (c *CachedSource) PerfInterval(entity types.ManagedObjectReference) (int32, error) {
+	result, err := c.cached("perf_interval:"+entity.String(), func() (interface{}, error) { return c.source.PerfInterval(entity) })
+	perfInterval, _ := result.(int32)
+	return perfInterval, err
+}
+
+// QueryPerf isn't cached as samples are only worth their latest value
+func (c *CachedSource) QueryPerf(entities []types.ManagedObjectReference, counters []string, interval int32) ([]performance.EntityMetric, error) {
+	return c.source.QueryPerf(entities, counters, interval)
+}
+
+func (c *CachedSource) Logout() error {
+	return c.source.Logout()
+}
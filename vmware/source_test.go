@@ -0,0 +1,88 @@
+package vmware
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// countingSource counts the calls reaching it, the methods not overridden panic
+type countingSource struct {
+	Source
+	mu    sync.Mutex
+	calls map[string]int
+	err   error
+}
+
+func (s *countingSource) count(method string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[method]++
+}
+
+func (s *countingSource) ListHost() ([]mo.HostSystem, error) {
+	s.count("ListHost")
+	// the source is slow, so concurrent callers overlap
+	time.Sleep(10 * time.Millisecond)
+	return []mo.HostSystem{{ManagedEntity: mo.ManagedEntity{Name: "esx01"}}}, s.err
+}
+
+func (s *countingSource) PerfInterval(entity types.ManagedObjectReference) (int32, error) {
+	s.count("PerfInterval " + entity.Value)
+	return 20, nil
+}
+
+func TestCachedSource(t *testing.T) {
+	source := &countingSource{calls: map[string]int{}}
+	cached := NewCachedSource(source, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hosts, err := cached.ListHost()
+			if err != nil || len(hosts) != 1 || hosts[0].Name != "esx01" {
+				t.Errorf("expected host esx01, got %v, %v", hosts, err)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, entity := range []string{"host-1", "host-2", "host-1"} {
+		if interval, err := cached.PerfInterval(types.ManagedObjectReference{Type: "HostSystem", Value: entity}); err != nil || interval != 20 {
+			t.Errorf("expected the interval of 20s, got %d, %v", interval, err)
+		}
+	}
+
+	expected := map[string]int{"ListHost": 1, "PerfInterval host-1": 1, "PerfInterval host-2": 1}
+	for method, count := range expected {
+		if source.calls[method] != count {
+			t.Errorf("expected %d calls of %s, got %d", count, method, source.calls[method])
+		}
+	}
+}
+
+func TestCachedSourceExpiry(t *testing.T) {
+	source := &countingSource{calls: map[string]int{}, err: errors.New("not responding")}
+	cached := NewCachedSource(source, 50*time.Millisecond)
+
+	// errors are cached as well
+	for i := 0; i < 2; i++ {
+		if _, err := cached.ListHost(); err == nil {
+			t.Errorf("expected the error of the source")
+		}
+	}
+	if source.calls["ListHost"] != 1 {
+		t.Errorf("expected the error to be cached, got %d calls", source.calls["ListHost"])
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	cached.ListHost()
+	if source.calls["ListHost"] != 2 {
+		t.Errorf("expected the expired result to be retrieved again, got %d calls", source.calls["ListHost"])
+	}
+}
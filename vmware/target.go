@@ -0,0 +1,84 @@
+package vmware
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// defaultSDKPath is the path of the vSphere web services API on both vCenter and ESXi
+const defaultSDKPath = "/sdk"
+
+// ParseTarget returns the URL of the vSphere API for a scrape target, which is one of
+//   - a bare host name or IP address, e.g. "vc01.example.com" or "10.36.51.11"
+//   - a host and port, e.g. "vc01.example.com:8443" or "[fd00::1]:8443", IPv6 literals are bracketed as in URLs
+//   - an IPv6 literal without brackets, e.g. "fd00::1", it can't carry a port
+//   - a full URL, e.g. "https://vc01.example.com/sdk", the path defaults to /sdk if it is empty
+//
+// Targets without scheme are reached over https.
+func ParseTarget(target string) (*url.URL, error) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return nil, fmt.Errorf("empty target")
+	}
+
+	if strings.Contains(target, "://") {
+		targetURL, err := url.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target URL %q, %v", target, err)
+		}
+		if targetURL.Scheme != "https" && targetURL.Scheme != "http" {
+			return nil, fmt.Errorf("invalid target URL %q, the scheme must be https or http", target)
+		}
+		if targetURL.Hostname() == "" {
+			return nil, fmt.Errorf("invalid target URL %q, missing host", target)
+		}
+		if targetURL.User != nil {
+			return nil, fmt.Errorf("invalid target URL %q, credentials belong to the cluster config", targetURL.Redacted())
+		}
+		if port := targetURL.Port(); port != "" {
+			if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+				return nil, fmt.Errorf("invalid target URL %q, invalid port %q", target, port)
+			}
+		}
+		if targetURL.Path == "" || targetURL.Path == "/" {
+			targetURL.Path = defaultSDKPath
+		}
+		return targetURL, nil
+	}
+
+	host := target
+	if strings.HasPrefix(target, "[") && strings.HasSuffix(target, "]") {
+		// a bracketed IPv6 literal without port, e.g. [fd00::1]
+		if net.ParseIP(strings.Trim(target, "[]")) == nil {
+			return nil, fmt.Errorf("invalid target %q, not an IPv6 address", target)
+		}
+	} else if strings.Count(target, ":") > 1 && !strings.HasPrefix(target, "[") {
+		// an IPv6 literal without brackets, e.g. fd00::1
+		if net.ParseIP(target) == nil {
+			return nil, fmt.Errorf("invalid target %q, IPv6 addresses with port must be written as [address]:port", target)
+		}
+		host = "[" + target + "]"
+	} else if strings.Contains(target, ":") {
+		hostname, port, err := net.SplitHostPort(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target %q, %v", target, err)
+		}
+		if hostname == "" || port == "" {
+			return nil, fmt.Errorf("invalid target %q, missing host or port", target)
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid target %q, invalid port %q", target, port)
+		}
+	} else if strings.ContainsAny(target, "/?#@ ") {
+		return nil, fmt.Errorf("invalid target %q, paths need a full URL such as https://%s", target, target)
+	}
+
+	return &url.URL{
+		Scheme: "https",
+		Host:   host,
+		Path:   defaultSDKPath,
+	}, nil
+}
@@ -0,0 +1,55 @@
+package vmware
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jenningsloy318/vsphere_exporter/config"
+)
+
+// defaults of the connections to a target, the same as the ones of http.DefaultTransport
+const (
+	defaultConnectTimeout = 30 * time.Second
+	defaultKeepAlive      = 30 * time.Second
+)
+
+// configureTransport applies the proxy, connect timeout and keep-alive of the cluster config to the transport of a SOAP client
+func configureTransport(transport *http.Transport, clusterConfig *config.ClusterConfig) error {
+	dialer := &net.Dialer{
+		Timeout:   defaultConnectTimeout,
+		KeepAlive: defaultKeepAlive,
+	}
+	if clusterConfig.ConnectTimeout > 0 {
+		dialer.Timeout = clusterConfig.ConnectTimeout
+	}
+	if clusterConfig.KeepAlive != 0 {
+		dialer.KeepAlive = clusterConfig.KeepAlive
+	}
+
+	// govmomi dials TLS connections on its own, which would skip the dialer, the timeout covers the TLS handshake as well
+	tlsConfig := transport.TLSClientConfig
+	transport.DialContext = dialer.DialContext
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: tlsConfig}
+		return tlsDialer.DialContext(ctx, network, addr)
+	}
+
+	if clusterConfig.ProxyURL != "" {
+		proxyURL, err := url.Parse(clusterConfig.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy_url, %v", err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("invalid proxy_url %s, the scheme must be http, https, socks5 or socks5h", proxyURL.Redacted())
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package vmware
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jenningsloy318/vsphere_exporter/config"
+)
+
+// tunnel copies the data between both connections until one of them is closed
+func tunnel(client net.Conn, upstream net.Conn) {
+	go func() {
+		io.Copy(upstream, client)
+		upstream.Close()
+	}()
+	io.Copy(client, upstream)
+	client.Close()
+}
+
+// startConnectProxy starts an HTTP CONNECT proxy and returns its URL and the number of tunnels it opened
+func startConnectProxy(t *testing.T) (string, *int32) {
+	var tunnels int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		atomic.AddInt32(&tunnels, 1)
+		w.WriteHeader(http.StatusOK)
+		client, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		tunnel(client, upstream)
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy.URL, &tunnels
+}
+
+// startSocks5Proxy starts a SOCKS5 proxy without authentication and returns its URL and the number of tunnels it opened
+func startSocks5Proxy(t *testing.T) (string, *int32) {
+	var tunnels int32
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error when listening, %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			client, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				// greeting: version, number of methods, methods; the reply selects no authentication
+				greeting := make([]byte, 2)
+				if _, err := io.ReadFull(client, greeting); err != nil {
+					client.Close()
+					return
+				}
+				io.ReadFull(client, make([]byte, greeting[1]))
+				client.Write([]byte{5, 0})
+
+				// request: version, command, reserved, address type, address, port
+				request := make([]byte, 4)
+				if _, err := io.ReadFull(client, request); err != nil {
+					client.Close()
+					return
+				}
+				var host string
+				switch request[3] {
+				case 1:
+					address := make([]byte, 4)
+					io.ReadFull(client, address)
+					host = net.IP(address).String()
+				case 3:
+					length := make([]byte, 1)
+					io.ReadFull(client, length)
+					address := make([]byte, length[0])
+					io.ReadFull(client, address)
+					host = string(address)
+				case 4:
+					address := make([]byte, 16)
+					io.ReadFull(client, address)
+					host = net.IP(address).String()
+				}
+				port := make([]byte, 2)
+				io.ReadFull(client, port)
+
+				upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+				if err != nil {
+					client.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					client.Close()
+					return
+				}
+				atomic.AddInt32(&tunnels, 1)
+				client.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				tunnel(client, upstream)
+			}()
+		}
+	}()
+	return "socks5://" + listener.Addr().String(), &tunnels
+}
+
+func TestProxy(t *testing.T) {
+	port := startTestListener(t, "127.0.0.1:0")
+	connectProxy, connectTunnels := startConnectProxy(t)
+	socks5Proxy, socks5Tunnels := startSocks5Proxy(t)
+
+	tests := []struct {
+		proxyURL string
+		tunnels  *int32
+	}{
+		{connectProxy, connectTunnels},
+		{socks5Proxy, socks5Tunnels},
+	}
+	for _, test := range tests {
+		clusterConfig := &config.ClusterConfig{Username: "user", Password: "pass", ProxyURL: test.proxyURL, ConnectTimeout: 5 * time.Second, KeepAlive: -1}
+		vmc, err := NewVMClient(context.Background(), "127.0.0.1:"+port, clusterConfig)
+		if err != nil {
+			t.Errorf("%s: error when creating vc client, %v", test.proxyURL, err)
+			continue
+		}
+		if _, err := vmc.ListHost(); err != nil {
+			t.Errorf("%s: error when listing hosts, %v", test.proxyURL, err)
+		}
+		vmc.Logout()
+		if atomic.LoadInt32(test.tunnels) == 0 {
+			t.Errorf("%s: expected the connection to go through the proxy", test.proxyURL)
+		}
+	}
+}
+
+func TestInvalidProxy(t *testing.T) {
+	for _, proxyURL := range []string{"ftp://proxy:21", "://proxy"} {
+		if _, err := NewVMClient(context.Background(), "127.0.0.1:1", &config.ClusterConfig{ProxyURL: proxyURL}); err == nil {
+			t.Errorf("%s: expected an error", proxyURL)
+		}
+	}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	// a listener that never accepts keeps the TLS handshake pending
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error when listening, %v", err)
+	}
+	defer listener.Close()
+
+	start := time.Now()
+	_, err = NewVMClient(context.Background(), listener.Addr().String(), &config.ClusterConfig{ConnectTimeout: 200 * time.Millisecond})
+	if err == nil {
+		t.Fatalf("expected the connection to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the connect timeout to apply, took %s", elapsed)
+	}
+}
@@ -4,128 +4,476 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"reflect"
 
-	"github.com/prometheus/common/log"
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/jenningsloy318/vsphere_exporter/logging"
+	"github.com/jenningsloy318/vsphere_exporter/tracing"
 	"github.com/vmware/govmomi"
 	"github.com/vmware/govmomi/performance"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vapi/rest"
+	"github.com/vmware/govmomi/vapi/tags"
 	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/soap"
 	"github.com/vmware/govmomi/vim25/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type VMClient struct {
 	ctx           context.Context
 	govmomiClient *govmomi.Client
+	about         types.AboutInfo
+	// user logs into the REST API, which the tags are retrieved from
+	user *url.Userinfo
+	// recorder saves the retrieved results with --record.dir
+	recorder *recording
 }
 
-func NewVMClient(context context.Context, vcHost string, username string, password string) (*VMClient, error) {
-
-	vcURL, err := soap.ParseURL(fmt.Sprintf("https://%s", vcHost))
+// NewVMClient logs into the target with the credentials of its cluster config, the URL of the cluster config, if set,
+// overrides the address of the target, see ParseTarget for the syntax of both
+func NewVMClient(ctx context.Context, target string, clusterConfig *config.ClusterConfig) (*VMClient, error) {
+	endpoint := target
+	if clusterConfig.URL != "" {
+		endpoint = clusterConfig.URL
+	}
+	logger := logging.FromContext(ctx)
+	vcURL, err := ParseTarget(endpoint)
 
 	if err != nil {
-		log.Errorf("error when parsing the vCenter URL, %v", err)
+		logger.Error("error when parsing the vCenter URL", "url", endpoint, "err", err)
 		return nil, err
 	}
 
-	vcURL.User = url.UserPassword(username, password)
+	vcURL.User = url.UserPassword(clusterConfig.Username, clusterConfig.Password)
 
-	newVcClient, err := govmomi.NewClient(context, vcURL, true)
+	var recorder *recording
+	if *recordDir != "" {
+		if recorder, err = newRecording(*recordDir, target); err != nil {
+			logger.Error("error when recording the target", "dir", *recordDir, "err", err)
+			return nil, err
+		}
+	}
 
+	// every SOAP call and the bytes it transfers are recorded by the api metrics
+	soapClient := soap.NewClient(vcURL, true)
+	if err := configureTransport(soapClient.DefaultTransport(), clusterConfig); err != nil {
+		logger.Error("error when configuring the connection", "err", err)
+		return nil, err
+	}
+	soapClient.Client.Transport = &instrumentedTransport{target: target, transport: soapClient.Client.Transport}
+	roundTripper := &instrumentedRoundTripper{target: target, roundTripper: soapClient}
+
+	var vim25Client *vim25.Client
+	err = roundTripper.observe(ctx, "RetrieveServiceContent", func(callCtx context.Context) error {
+		vim25Client, err = vim25.NewClient(callCtx, soapClient)
+		return err
+	})
 	if err != nil {
-		log.Errorf("error when creating new vCenter client, %v", err)
+		logger.Error("error when creating new vCenter client", "err", err)
 		return nil, err
 	}
-	return &VMClient{
-		ctx:           context,
+	vim25Client.RoundTripper = roundTripper
+
+	newVcClient := &govmomi.Client{
+		Client:         vim25Client,
+		SessionManager: session.NewManager(vim25Client),
+	}
+	if err := newVcClient.Login(ctx, vcURL.User); err != nil {
+		logger.Error("error when logging into the vCenter", "err", err)
+		return nil, err
+	}
+	vmc := &VMClient{
+		ctx:           ctx,
 		govmomiClient: newVcClient,
-	}, nil
+		about:         newVcClient.ServiceContent.About,
+		user:          vcURL.User,
+	}
+	if recorder != nil {
+		vmc.recorder = recorder
+		vmc.record("about", &vmc.about)
+	}
+	return vmc, nil
 }
 
-func (vmc *VMClient) ListVirtualMachine() ([]mo.VirtualMachine, error) {
-	vim25Client := vmc.govmomiClient.Client
-	ctx := vmc.ctx
-	viewManager := view.NewManager(vim25Client)
+// retrieve fills result, a pointer, by fetching it from the target, it is recorded if enabled.
+// The fetch is traced as a span carrying the number of objects retrieved, its vSphere API calls are traced as children.
+func (vmc *VMClient) retrieve(name string, result interface{}, fetch func(ctx context.Context) error) error {
+	ctx, span := tracing.Tracer.Start(vmc.ctx, "retrieve "+name, trace.WithAttributes(tracing.ResultKey.String(name)))
+	err := fetch(ctx)
+	if err == nil {
+		if value := reflect.ValueOf(result).Elem(); value.Kind() == reflect.Slice {
+			span.SetAttributes(tracing.ObjectsKey.Int(value.Len()))
+			logging.FromContext(vmc.ctx).Debug("retrieved objects", "result", name, "objects", value.Len())
+		}
+		vmc.record(name, result)
+	}
+	tracing.EndWithError(span, err)
+	return err
+}
 
-	virtualMachineListView, err := viewManager.CreateContainerView(ctx, vim25Client.ServiceContent.RootFolder, []string{"VirtualMachine"}, true)
-	if err != nil {
-		return nil, err
+// record saves the result if recording is enabled, a failure doesn't fail the scrape
+func (vmc *VMClient) record(name string, result interface{}) {
+	if vmc.recorder == nil {
+		return
 	}
+	if err := vmc.recorder.save(name, result); err != nil {
+		logging.FromContext(vmc.ctx).Error("error when recording a result", "result", name, "dir", vmc.recorder.dir, "err", err)
+	}
+}
 
+func (vmc *VMClient) ListVirtualMachine() ([]mo.VirtualMachine, error) {
 	var virtualMachineList []mo.VirtualMachine
-	//https://code.vmware.com/apis/358/vsphere/doc/vim.VirtualMachine.html, VirtualMachine has multiple properties, but here we choose "summary","config","guest","guestHeartbeatStatus","runtime"
-	err = virtualMachineListView.Retrieve(ctx, []string{"VirtualMachine"}, []string{"summary", "config", "guest", "guestHeartbeatStatus", "runtime"}, &virtualMachineList)
+	err := vmc.retrieve("virtual_machines", &virtualMachineList, func(ctx context.Context) error {
+		vim25Client := vmc.govmomiClient.Client
+		viewManager := view.NewManager(vim25Client)
+
+		virtualMachineListView, err := viewManager.CreateContainerView(ctx, vim25Client.ServiceContent.RootFolder, []string{"VirtualMachine"}, true)
+		if err != nil {
+			return err
+		}
+
+		//https://code.vmware.com/apis/358/vsphere/doc/vim.VirtualMachine.html, VirtualMachine has multiple properties, but here we choose "summary","config","guest","guestHeartbeatStatus","runtime", and "resourcePool" for the filters
+		return virtualMachineListView.Retrieve(ctx, []string{"VirtualMachine"}, []string{"summary", "config", "guest", "guestHeartbeatStatus", "runtime", "resourcePool"}, &virtualMachineList)
+	})
 	return virtualMachineList, err
 }
 
 func (vmc *VMClient) ListHost() ([]mo.HostSystem, error) {
-	vim25Client := vmc.govmomiClient.Client
-	ctx := vmc.ctx
-	viewManager := view.NewManager(vim25Client)
-	// containerView has multiple properties when creating the container view, mainy categoried as 5 types,  Folder / Datacenter /ComputeResource /ResourcePool /HostSystem,here we can use RootFolder, which is a Folder, for full list of supported property
+	var hostSystemList []mo.HostSystem
+	err := vmc.retrieve("hosts", &hostSystemList, func(ctx context.Context) error {
+		vim25Client := vmc.govmomiClient.Client
+		viewManager := view.NewManager(vim25Client)
+		// containerView has multiple properties when creating the container view, mainy categoried as 5 types,  Folder / Datacenter /ComputeResource /ResourcePool /HostSystem,here we can use RootFolder, which is a Folder, for full list of supported property
 
-	hostSystemListView, err := viewManager.CreateContainerView(ctx, vim25Client.ServiceContent.RootFolder, []string{"HostSystem"}, true)
-	if err != nil {
-		return nil, err
-	}
+		hostSystemListView, err := viewManager.CreateContainerView(ctx, vim25Client.ServiceContent.RootFolder, []string{"HostSystem"}, true)
+		if err != nil {
+			return err
+		}
 
-	var hostSystemList []mo.HostSystem
-	// https://code.vmware.com/apis/358/vsphere/doc/vim.HostSystem.html, here HostSystem has multiple Properties that can be retrieved, but here we choose "summary","runtime","hardware","config","capability"
-	err = hostSystemListView.Retrieve(ctx, []string{"HostSystem"}, []string{"summary", "runtime", "hardware", "config", "capability"}, &hostSystemList)
+		// https://code.vmware.com/apis/358/vsphere/doc/vim.HostSystem.html, here HostSystem has multiple Properties that can be retrieved, but here we choose "summary","runtime","hardware","config","capability","configManager"
+		return hostSystemListView.Retrieve(ctx, []string{"HostSystem"}, []string{"summary", "runtime", "hardware", "config", "capability", "configManager"}, &hostSystemList)
+	})
 	return hostSystemList, err
-
 }
 
-func (vmc *VMClient) ListDatastore() ([]mo.Datastore, error) {
-	vim25Client := vmc.govmomiClient.Client
-	ctx := vmc.ctx
-	viewManager := view.NewManager(vim25Client)
+// ListHealthSystemRuntime retrieves the runtime of the HostHealthStatusSystem of each host, keyed by the host reference.
+// It is the source of the health data for hosts whose runtime doesn't carry a healthSystemRuntime.
+func (vmc *VMClient) ListHealthSystemRuntime(hosts []mo.HostSystem) (map[types.ManagedObjectReference]types.HealthSystemRuntime, error) {
+	hostMapping := map[types.ManagedObjectReference]types.ManagedObjectReference{}
+	var healthStatusSystemRefs []types.ManagedObjectReference
+	for _, host := range hosts {
+		if host.ConfigManager.HealthStatusSystem != nil {
+			hostMapping[*host.ConfigManager.HealthStatusSystem] = host.Self
+			healthStatusSystemRefs = append(healthStatusSystemRefs, *host.ConfigManager.HealthStatusSystem)
+		}
+	}
+	healthSystemRuntimes := map[types.ManagedObjectReference]types.HealthSystemRuntime{}
+	if len(healthStatusSystemRefs) == 0 {
+		return healthSystemRuntimes, nil
+	}
 
-	datastoreListView, err := viewManager.CreateContainerView(ctx, vim25Client.ServiceContent.RootFolder, []string{"Datastore"}, true)
+	var healthStatusSystemList []mo.HostHealthStatusSystem
+	err := vmc.retrieve("health_status_systems", &healthStatusSystemList, func(ctx context.Context) error {
+		// https://code.vmware.com/apis/358/vsphere/doc/vim.host.HealthStatusSystem.html, only "runtime" is needed
+		return property.DefaultCollector(vmc.govmomiClient.Client).Retrieve(ctx, healthStatusSystemRefs, []string{"runtime"}, &healthStatusSystemList)
+	})
 	if err != nil {
 		return nil, err
 	}
+	for _, healthStatusSystem := range healthStatusSystemList {
+		healthSystemRuntimes[hostMapping[healthStatusSystem.Self]] = healthStatusSystem.Runtime
+	}
+	return healthSystemRuntimes, nil
+}
 
+func (vmc *VMClient) ListDatastore() ([]mo.Datastore, error) {
 	var datastoreList []mo.Datastore
-	//https://code.vmware.com/apis/358/vsphere/doc/vim.Datastore.html, datastore have several properties, we choose "summary","info"
-	err = datastoreListView.Retrieve(ctx, []string{"Datastore"}, []string{"summary", "info"}, &datastoreList)
-	return datastoreList, err
+	err := vmc.retrieve("datastores", &datastoreList, func(ctx context.Context) error {
+		vim25Client := vmc.govmomiClient.Client
+		viewManager := view.NewManager(vim25Client)
 
+		datastoreListView, err := viewManager.CreateContainerView(ctx, vim25Client.ServiceContent.RootFolder, []string{"Datastore"}, true)
+		if err != nil {
+			return err
+		}
+
+		//https://code.vmware.com/apis/358/vsphere/doc/vim.Datastore.html, datastore have several properties, we choose "summary","info"
+		return datastoreListView.Retrieve(ctx, []string{"Datastore"}, []string{"summary", "info"}, &datastoreList)
+	})
+	return datastoreList, err
 }
 
 func (vmc *VMClient) ListNetwork() ([]mo.Network, error) {
-	vim25Client := vmc.govmomiClient.Client
-	ctx := vmc.ctx
-	viewManager := view.NewManager(vim25Client)
+	var networkList []mo.Network
+	err := vmc.retrieve("networks", &networkList, func(ctx context.Context) error {
+		vim25Client := vmc.govmomiClient.Client
+		viewManager := view.NewManager(vim25Client)
+
+		networkListView, err := viewManager.CreateContainerView(ctx, vim25Client.ServiceContent.RootFolder, []string{"Network"}, true)
+		if err != nil {
+			return err
+		}
+
+		// https://code.vmware.com/apis/358/vsphere/doc/vim.Network.html, network only have four properties, we choose "name" and "summary"
+		return networkListView.Retrieve(ctx, []string{"Network"}, []string{"summary", "name"}, &networkList)
+	})
+	return networkList, err
+}
 
-	networkListView, err := viewManager.CreateContainerView(ctx, vim25Client.ServiceContent.RootFolder, []string{"Network"}, true)
+// ListCluster retrieves the clusters with their summary and configuration
+func (vmc *VMClient) ListCluster() ([]mo.ClusterComputeResource, error) {
+	var clusterList []mo.ClusterComputeResource
+	err := vmc.retrieve("clusters", &clusterList, func(ctx context.Context) error {
+		vim25Client := vmc.govmomiClient.Client
+		viewManager := view.NewManager(vim25Client)
+
+		clusterListView, err := viewManager.CreateContainerView(ctx, vim25Client.ServiceContent.RootFolder, []string{"ClusterComputeResource"}, true)
+		if err != nil {
+			return err
+		}
+		defer clusterListView.Destroy(ctx)
+
+		// https://code.vmware.com/apis/358/vsphere/doc/vim.ClusterComputeResource.html, we choose "name", "summary", "configurationEx", "host" and "overallStatus"
+		return clusterListView.Retrieve(ctx, []string{"ClusterComputeResource"}, []string{"name", "summary", "configurationEx", "host", "overallStatus"}, &clusterList)
+	})
+	return clusterList, err
+}
+
+// ListEntities retrieves the name and parent of every managed entity, which the inventory is built from
+func (vmc *VMClient) ListEntities() ([]types.ObjectContent, error) {
+	var entityList []types.ObjectContent
+	err := vmc.retrieve("entities", &entityList, func(ctx context.Context) error {
+		vim25Client := vmc.govmomiClient.Client
+		viewManager := view.NewManager(vim25Client)
+
+		entityListView, err := viewManager.CreateContainerView(ctx, vim25Client.ServiceContent.RootFolder, []string{"ManagedEntity"}, true)
+		if err != nil {
+			return err
+		}
+		defer entityListView.Destroy(ctx)
+
+		// https://code.vmware.com/apis/358/vsphere/doc/vim.ManagedEntity.html, only "name" and "parent" are needed to build the tree
+		return entityListView.Retrieve(ctx, []string{"ManagedEntity"}, []string{"name", "parent"}, &entityList)
+	})
+	return entityList, err
+}
+
+// RetrieveProperties retrieves the property paths of every managed object of the type, it is recorded as properties_<type>
+func (vmc *VMClient) RetrieveProperties(objectType string, paths []string) ([]types.ObjectContent, error) {
+	var objectList []types.ObjectContent
+	err := vmc.retrieve("properties_"+objectType, &objectList, func(ctx context.Context) error {
+		vim25Client := vmc.govmomiClient.Client
+		viewManager := view.NewManager(vim25Client)
+
+		objectListView, err := viewManager.CreateContainerView(ctx, vim25Client.ServiceContent.RootFolder, []string{objectType}, true)
+		if err != nil {
+			return err
+		}
+		defer objectListView.Destroy(ctx)
+
+		return objectListView.Retrieve(ctx, []string{objectType}, paths, &objectList)
+	})
+	return objectList, err
+}
+
+// ListTags retrieves the tags attached to the managed objects, as "<category>/<tag>", from the REST API of the vCenter.
+// Standalone ESXi hosts have no tags.
+func (vmc *VMClient) ListTags() (map[types.ManagedObjectReference][]string, error) {
+	var taggedObjectList []taggedObjectRecord
+	err := vmc.retrieve("tags", &taggedObjectList, func(ctx context.Context) error {
+		if !vmc.IsVCenter() {
+			return nil
+		}
+		restClient := rest.NewClient(vmc.govmomiClient.Client)
+		if err := restClient.Login(ctx, vmc.user); err != nil {
+			return err
+		}
+		defer restClient.Logout(ctx)
+
+		tagManager := tags.NewManager(restClient)
+		categoryList, err := tagManager.GetCategories(ctx)
+		if err != nil {
+			return err
+		}
+		categoryNames := map[string]string{}
+		for _, category := range categoryList {
+			categoryNames[category.ID] = category.Name
+		}
+		tagList, err := tagManager.GetTags(ctx)
+		if err != nil || len(tagList) == 0 {
+			return err
+		}
+		tagNames := map[string]string{}
+		var tagIDs []string
+		for _, tag := range tagList {
+			tagNames[tag.ID] = categoryNames[tag.CategoryID] + "/" + tag.Name
+			tagIDs = append(tagIDs, tag.ID)
+		}
+		attachedObjectList, err := tagManager.GetAttachedObjectsOnTags(ctx, tagIDs)
+		if err != nil {
+			return err
+		}
+		objectTags := map[types.ManagedObjectReference][]string{}
+		for _, attachedObjects := range attachedObjectList {
+			for _, object := range attachedObjects.ObjectIDs {
+				objectTags[object.Reference()] = append(objectTags[object.Reference()], tagNames[attachedObjects.TagID])
+			}
+		}
+		for object, objectTagNames := range objectTags {
+			taggedObjectList = append(taggedObjectList, taggedObjectRecord{Object: object, Tags: objectTagNames})
+		}
+		return nil
+	})
 	if err != nil {
+		logging.FromContext(vmc.ctx).Error("error when getting tags from vcenter", "err", err)
 		return nil, err
 	}
+	return taggedObjects(taggedObjectList), nil
+}
 
-	var networkList []mo.Network
-	// https://code.vmware.com/apis/358/vsphere/doc/vim.Network.html, network only have four properties, we choose "name" and "summary"
-	err = networkListView.Retrieve(ctx, []string{"Network"}, []string{"summary", "name"}, &networkList)
-	return networkList, err
-
+// PrivilegesGranted checks the privileges of the session on the root folder with AuthorizationManager.HasPrivilegeOnEntities,
+// the privileges granted on the root folder propagate to the whole inventory
+func (vmc *VMClient) PrivilegesGranted(privileges []string) (map[string]bool, error) {
+	var privilegeList []types.PrivilegeAvailability
+	err := vmc.retrieve("privileges", &privilegeList, func(ctx context.Context) error {
+		vim25Client := vmc.govmomiClient.Client
+		if vim25Client.ServiceContent.AuthorizationManager == nil {
+			return fmt.Errorf("the target has no authorization manager")
+		}
+		userSession, err := vmc.govmomiClient.SessionManager.UserSession(ctx)
+		if err != nil {
+			return err
+		}
+		if userSession == nil {
+			return fmt.Errorf("no session to check the privileges of")
+		}
+		res, err := methods.HasPrivilegeOnEntities(ctx, vim25Client, &types.HasPrivilegeOnEntities{
+			This:      *vim25Client.ServiceContent.AuthorizationManager,
+			Entity:    []types.ManagedObjectReference{vim25Client.ServiceContent.RootFolder},
+			SessionId: userSession.Key,
+			PrivId:    privileges,
+		})
+		if err != nil {
+			return err
+		}
+		for _, entityPrivilege := range res.Returnval {
+			privilegeList = append(privilegeList, entityPrivilege.PrivAvailability...)
+		}
+		return nil
+	})
+	if err != nil {
+		logging.FromContext(vmc.ctx).Error("error when checking the privileges of the session", "err", err)
+		return nil, err
+	}
+	return privilegesGranted(privilegeList, privileges)
 }
 
 func (vmc *VMClient) ListPerfCounters() (map[string]*types.PerfCounterInfo, error) {
+	var perfCounterList []types.PerfCounterInfo
+	err := vmc.retrieve("perf_counters", &perfCounterList, func(ctx context.Context) error {
+		var err error
+		perfCounterList, err = performance.NewManager(vmc.govmomiClient.Client).CounterInfo(ctx)
+		return err
+	})
+	if err != nil {
+		logging.FromContext(vmc.ctx).Error("error when getting perf counters from vcenter", "err", err)
+		return nil, err
+	}
+
+	perfCounters := make(map[string]*types.PerfCounterInfo, len(perfCounterList))
+	for i := range perfCounterList {
+		perfCounters[perfCounterList[i].Name()] = &perfCounterList[i]
+	}
+	return perfCounters, nil
+}
+
+// IsVCenter reports if the target is a vCenter, it is false for a standalone ESXi host
+func (vmc *VMClient) IsVCenter() bool {
+	return vmc.about.ApiType == "VirtualCenter"
+}
+
+// About returns the product information of the target
+func (vmc *VMClient) About() types.AboutInfo {
+	return vmc.about
+}
+
+// PerfInterval returns the interval in seconds the perf counters of the entity are sampled at.
+// A standalone ESXi host only has the host-local real-time provider, a vCenter keeps the historical intervals
+// for the entities without real-time statistics, such as clusters and datastores.
+func (vmc *VMClient) PerfInterval(entity types.ManagedObjectReference) (int32, error) {
+	perfInterval, err := vmc.perfInterval(entity)
+	if err == nil && vmc.recorder != nil {
+		// the intervals of all the entities queried by a scrape are recorded together
+		vmc.recorder.Lock()
+		vmc.recorder.perfIntervals = append(vmc.recorder.perfIntervals, perfIntervalRecord{Entity: entity, Interval: perfInterval})
+		perfIntervals := append([]perfIntervalRecord(nil), vmc.recorder.perfIntervals...)
+		vmc.recorder.Unlock()
+		vmc.record("perf_intervals", &perfIntervals)
+	}
+	return perfInterval, err
+}
+
+// QueryPerf returns the latest sample of the named counters, e.g. "cpu.usage.average", of the entities at the given interval
+func (vmc *VMClient) QueryPerf(entities []types.ManagedObjectReference, counters []string, interval int32) (series []performance.EntityMetric, err error) {
+	ctx, span := tracing.Tracer.Start(vmc.ctx, "query perf", trace.WithAttributes(tracing.ObjectsKey.Int(len(entities)), attribute.StringSlice("vsphere.counters", counters)))
+	defer func() { tracing.EndWithError(span, err) }()
+
+	perfManager := performance.NewManager(vmc.govmomiClient.Client)
+	spec := types.PerfQuerySpec{
+		MaxSample:  1,
+		IntervalId: interval,
+	}
+	sample, err := perfManager.SampleByName(ctx, spec, counters, entities)
+	if err != nil {
+		return nil, err
+	}
+	series, err = perfManager.ToMetricSeries(ctx, sample)
+	if err == nil && vmc.recorder != nil {
+		// the queries of a scrape are recorded together
+		vmc.recorder.Lock()
+		vmc.recorder.perfQueries = append(vmc.recorder.perfQueries, perfQueryRecord{Entities: entities, Counters: counters, Interval: interval, Series: series})
+		perfQueries := append([]perfQueryRecord(nil), vmc.recorder.perfQueries...)
+		vmc.recorder.Unlock()
+		vmc.record("perf_queries", &perfQueries)
+	}
+	return series, err
+}
+
+func (vmc *VMClient) perfInterval(entity types.ManagedObjectReference) (int32, error) {
 	vim25Client := vmc.govmomiClient.Client
 	ctx := vmc.ctx
 
 	perfManager := performance.NewManager(vim25Client)
-	perfCounters, err := perfManager.CounterInfoByName(ctx)
+	providerSummary, err := perfManager.ProviderSummary(ctx, entity)
 	if err != nil {
-		log.Errorf("error when getting perf counters from vcenter, %v", err)
-		return nil, err
+		return 0, err
+	}
+	if providerSummary.CurrentSupported {
+		return providerSummary.RefreshRate, nil
+	}
+	if !vmc.IsVCenter() {
+		return 0, fmt.Errorf("no real-time perf provider for %s on ESXi host %s", entity, vim25Client.URL().Host)
 	}
-	return perfCounters, nil
 
+	historicalIntervals, err := perfManager.HistoricalInterval(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, historicalInterval := range historicalIntervals {
+		if historicalInterval.Enabled {
+			return historicalInterval.SamplingPeriod, nil
+		}
+	}
+	return 0, fmt.Errorf("no enabled historical perf interval on vCenter %s", vim25Client.URL().Host)
 }
 
 func (vmc *VMClient) Logout() error {
-
 	err := vmc.govmomiClient.Logout(vmc.ctx)
 	return err
 
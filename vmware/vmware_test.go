@@ -2,106 +2,131 @@ package vmware
 
 import (
 	"context"
+	"crypto/tls"
 	"testing"
-)
-
-var vsHost = "10.36.51.11"
-var user = "user"
-var pass = "pass"
 
-func TestVC(t *testing.T) {
+	"github.com/jenningsloy318/vsphere_exporter/config"
+	"github.com/vmware/govmomi/simulator"
+)
 
-	ctx := context.Background()
-	newVC, err := NewVMClient(ctx, vsHost, user, pass)
+func newTestVMClient(t *testing.T, model *simulator.Model) *VMClient {
+	t.Helper()
+	if err := model.Create(); err != nil {
+		t.Fatalf("Error when creating simulator model, %v", err)
+	}
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+	t.Cleanup(func() {
+		server.Close()
+		model.Remove()
+	})
+
+	vmc, err := NewVMClient(context.Background(), server.URL.Host, &config.ClusterConfig{Username: "user", Password: "pass"})
 	if err != nil {
-		t.Logf("Error when creating vc client, %v", err)
-		return
+		t.Fatalf("Error when creating vc client, %v", err)
 	}
-	if newVC.govmomiClient.IsVC() {
-		t.Logf("This is a vCenter")
+	t.Cleanup(func() { vmc.Logout() })
+	return vmc
+}
+
+func TestVC(t *testing.T) {
+	vc := newTestVMClient(t, simulator.VPX())
+	if !vc.govmomiClient.IsVC() {
+		t.Errorf("expected a vCenter")
 	}
 }
 
 func TestVcHost(t *testing.T) {
-	ctx := context.Background()
-	newVC, err := NewVMClient(ctx, vsHost, user, pass)
+	model := simulator.VPX()
+	vc := newTestVMClient(t, model)
+	hosts, err := vc.ListHost()
 	if err != nil {
-		t.Logf("Error when creating vc client, %v", err)
-		return
+		t.Fatalf("Error when listing hosts, %v", err)
 	}
-	hosts, err := newVC.ListHost()
-	if err != nil {
-		t.Logf("Error when listing hosts, %v", err)
-		return
+	// the standalone hosts and the hosts of the cluster
+	if expected := model.Host + model.ClusterHost; len(hosts) != expected {
+		t.Fatalf("expected %d hosts, got %d", expected, len(hosts))
+	}
+	for _, host := range hosts {
+		if host.Summary.Config.Name == "" || host.ConfigManager.HealthStatusSystem == nil {
+			t.Errorf("expected the summary and config manager of host %s", host.Self.Value)
+		}
 	}
-
-	t.Logf("hosts %#v\n", hosts[0])
 }
+
 func TestVcVM(t *testing.T) {
-	ctx := context.Background()
-	newVC, err := NewVMClient(ctx, vsHost, user, pass)
+	model := simulator.VPX()
+	vc := newTestVMClient(t, model)
+	VMs, err := vc.ListVirtualMachine()
 	if err != nil {
-		t.Logf("Error when creating vc client, %v", err)
-		return
+		t.Fatalf("Error when listing virtual machines, %v", err)
 	}
-	VMs, err := newVC.ListVirtualMachine()
-	if err != nil {
-		t.Logf("Error when listing virtual machines, %v", err)
-		return
+	// every standalone host and every cluster runs model.Machine vms
+	if expected := (model.Host + model.Cluster) * model.Machine; len(VMs) != expected {
+		t.Fatalf("expected %d virtual machines, got %d", expected, len(VMs))
+	}
+	for _, vm := range VMs {
+		if vm.Summary.Runtime.Host == nil {
+			t.Errorf("expected the host of virtual machine %s", vm.Self.Value)
+		}
 	}
-	t.Logf("Virtual machines %#v\n", VMs[0])
-
 }
 
 func TestVcDatastore(t *testing.T) {
-	ctx := context.Background()
-	newVC, err := NewVMClient(ctx, vsHost, user, pass)
+	vc := newTestVMClient(t, simulator.VPX())
+	datastores, err := vc.ListDatastore()
 	if err != nil {
-		t.Logf("Error when creating vc client, %v", err)
-		return
+		t.Fatalf("Error when listing datastores, %v", err)
 	}
-	datastores, err := newVC.ListDatastore()
-	if err != nil {
-		t.Logf("Error when listing datastores, %v", err)
-		return
+	if len(datastores) != 1 || datastores[0].Summary.Name != "LocalDS_0" {
+		t.Errorf("expected datastore LocalDS_0, got %#v", datastores)
 	}
-
-	t.Logf("Datastore %#v\n", datastores[0])
-
 }
 
 func TestVcNetwork(t *testing.T) {
-	ctx := context.Background()
-	newVC, err := NewVMClient(ctx, vsHost, user, pass)
+	vc := newTestVMClient(t, simulator.VPX())
+	networks, err := vc.ListNetwork()
 	if err != nil {
-		t.Logf("Error when creating vc client, %v", err)
-		return
+		t.Fatalf("Error when listing networks, %v", err)
 	}
-	networks, err := newVC.ListNetwork()
-	if err != nil {
-		t.Logf("Error when listing networks, %v", err)
-		return
+	if len(networks) == 0 {
+		t.Errorf("expected networks")
 	}
-
-	t.Logf("Networks %#v\n", networks[0])
-
 }
 
 func TestVcPerfCounters(t *testing.T) {
-	ctx := context.Background()
-	newVC, err := NewVMClient(ctx, vsHost, user, pass)
+	vc := newTestVMClient(t, simulator.VPX())
+	perfCounters, err := vc.ListPerfCounters()
 	if err != nil {
-		t.Logf("Error when creating vc client, %v", err)
-		return
+		t.Fatalf("Error when listing perf counters, %v", err)
 	}
-	perfCounters, err := newVC.ListPerfCounters()
-	if err != nil {
-		t.Logf("Error when listing networks, %v", err)
-		return
+	if _, ok := perfCounters["cpu.usage.average"]; !ok {
+		t.Errorf("expected perf counter cpu.usage.average, got %d counters", len(perfCounters))
 	}
+}
 
-	for name, perfCounter := range perfCounters {
-		t.Logf("Perf Counters %#v\n", perfCounters)
+func TestPerfInterval(t *testing.T) {
+	esx := newTestVMClient(t, simulator.ESX())
+	if esx.IsVCenter() {
+		t.Errorf("expected an ESXi host")
+	}
+	hosts, err := esx.ListHost()
+	if err != nil || len(hosts) != 1 {
+		t.Fatalf("Error when listing hosts, %v", err)
+	}
+	if interval, err := esx.PerfInterval(hosts[0].Self); err != nil || interval != 20 {
+		t.Errorf("expected the real-time interval of 20s, got %d, %v", interval, err)
 	}
 
+	vc := newTestVMClient(t, simulator.VPX())
+	if !vc.IsVCenter() {
+		t.Errorf("expected a vCenter")
+	}
+	datastores, err := vc.ListDatastore()
+	if err != nil || len(datastores) == 0 {
+		t.Fatalf("Error when listing datastores, %v", err)
+	}
+	if interval, err := vc.PerfInterval(datastores[0].Self); err != nil || interval != 300 {
+		t.Errorf("expected the historical interval of 300s, got %d, %v", interval, err)
+	}
 }